@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+	"github.com/refset/fluxnova-decision-observability/internal/pipeline"
+)
+
+func runBackfill(args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	from := fs.String("from", "", "start of the range (RFC3339 or YYYY-MM-DD, required)")
+	to := fs.String("to", "", "end of the range (RFC3339 or YYYY-MM-DD, default now)")
+	window := fs.Duration("window", 24*time.Hour, "width of each history query window")
+	rate := fs.Float64("rate", 10, "maximum process instances published per second (0 for unlimited)")
+	source := fs.String("source", "", "id of the source to backfill (default the first source)")
+	checkpoint := fs.String("checkpoint", "backfill.checkpoint", "file recording progress for resuming")
+	loader := config.NewLoader(fs)
+	fs.Parse(args)
+
+	if *from == "" {
+		return errors.New("backfill: --from is required")
+	}
+	fromTime, err := parseBackfillTime(*from)
+	if err != nil {
+		return fmt.Errorf("backfill: --from: %w", err)
+	}
+	toTime := time.Now().UTC()
+	if *to != "" {
+		if toTime, err = parseBackfillTime(*to); err != nil {
+			return fmt.Errorf("backfill: --to: %w", err)
+		}
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return err
+	}
+
+	p, err := pipeline.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create pipeline: %w", err)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	return p.Backfill(ctx, pipeline.BackfillOptions{
+		SourceID:       *source,
+		From:           fromTime,
+		To:             toTime,
+		Window:         *window,
+		Rate:           *rate,
+		CheckpointFile: *checkpoint,
+	})
+}
+
+func parseBackfillTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/kafka"
+)
+
+func runCheckConfig(args []string) error {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	xtdbConn := fs.String("xtdb", os.Getenv("XTDB_CONN_STRING"), "XTDB connection string to test (skipped if empty)")
+	offline := fs.Bool("offline", false, "only validate the configuration, without testing connectivity")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each connectivity check")
+	loader := config.NewLoader(fs)
+	fs.Parse(args)
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Configuration is valid")
+	if *offline {
+		return nil
+	}
+
+	failed := 0
+	check := func(name string, fn func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		if err := fn(ctx); err != nil {
+			fmt.Printf("  FAIL  %s: %v\n", name, err)
+			failed++
+			return
+		}
+		fmt.Printf("  ok    %s\n", name)
+	}
+
+	for _, src := range cfg.EffectiveSources() {
+		switch src.Type {
+		case config.SourceTypePoll:
+			check(fmt.Sprintf("source %s: Fluxnova at %s", src.ID, src.BaseURL), func(ctx context.Context) error {
+				client, err := fluxnova.NewClientFromConfig(src.FluxnovaConfig)
+				if err != nil {
+					return err
+				}
+				return client.Ping()
+			})
+		case config.SourceTypeDatabase:
+			check(fmt.Sprintf("source %s: engine database", src.ID), func(ctx context.Context) error {
+				db, err := fluxnova.NewDBPoller(ctx, src.DatabaseURL.Value, cfg.Pipeline.BatchSize)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+				return db.Ping(ctx)
+			})
+		case config.SourceTypeWebhook:
+			check(fmt.Sprintf("source %s: listen on %s", src.ID, src.Stream.ListenAddr), func(ctx context.Context) error {
+				ln, err := net.Listen("tcp", src.Stream.ListenAddr)
+				if err != nil {
+					return err
+				}
+				return ln.Close()
+			})
+		case config.SourceTypeKafka:
+			check(fmt.Sprintf("source %s: Kafka topic %s", src.ID, src.Stream.Topic), func(ctx context.Context) error {
+				return kafka.CheckTopics(ctx, cfg.Kafka.Brokers, src.Stream.Topic)
+			})
+		}
+	}
+
+	switch cfg.Sink.Type {
+	case config.SinkTypeKafka:
+		check(fmt.Sprintf("Kafka brokers %v", cfg.Kafka.Brokers), func(ctx context.Context) error {
+			topics := []string{cfg.Kafka.EventsTopic, cfg.Kafka.ProcessesTopic, cfg.Kafka.VariablesTopic, cfg.Kafka.PurgesTopic}
+			if sla := cfg.Pipeline.SLA; len(sla.Rules) > 0 || sla.StuckAfter > 0 {
+				topics = append(topics, cfg.Kafka.BreachesTopic)
+			}
+			return kafka.CheckTopics(ctx, cfg.Kafka.Brokers, topics...)
+		})
+	case config.SinkTypeNDJSON:
+		if path := cfg.Sink.NDJSON.Path; path != "" && path != "-" {
+			check(fmt.Sprintf("NDJSON sink file %s", path), func(ctx context.Context) error {
+				f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+				if err != nil {
+					return err
+				}
+				return f.Close()
+			})
+		}
+	case config.SinkTypeParquet:
+		check(fmt.Sprintf("Parquet sink directory %s", cfg.Sink.Parquet.Dir), func(ctx context.Context) error {
+			if err := os.MkdirAll(cfg.Sink.Parquet.Dir, 0o755); err != nil {
+				return err
+			}
+			f, err := os.CreateTemp(cfg.Sink.Parquet.Dir, ".check-*")
+			if err != nil {
+				return err
+			}
+			f.Close()
+			return os.Remove(f.Name())
+		})
+	}
+
+	if *xtdbConn == "" {
+		fmt.Println("  skip  XTDB (no --xtdb or XTDB_CONN_STRING)")
+	} else {
+		check("XTDB", func(ctx context.Context) error {
+			conn, err := pgx.Connect(ctx, *xtdbConn)
+			if err != nil {
+				return err
+			}
+			defer conn.Close(context.Background())
+			return conn.Ping(ctx)
+		})
+	}
+
+	if failed > 0 {
+		return errors.New(pluralize(failed, "connectivity check") + " failed")
+	}
+	return nil
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
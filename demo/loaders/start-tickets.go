@@ -106,7 +106,7 @@ func main() {
 		req := StartProcessRequest{
 			BusinessKey: ticketID,
 			Variables: map[string]Variable{
-				"ticket":     {Value: string(ticketJSON), Type: "String"},
+				"ticket":     {Value: string(ticketJSON), Type: "Json"},
 				"ticketId":   {Value: ticketID, Type: "String"},
 				"customerId": {Value: ticket.CustomerID, Type: "String"},
 				"subject":    {Value: ticket.Subject, Type: "String"},
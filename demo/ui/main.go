@@ -7,13 +7,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/refset/fluxnova-decision-observability/demo/xtdb"
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
 )
 
 //go:embed templates/*
@@ -23,10 +26,20 @@ var tmpl *template.Template
 var db *pgxpool.Pool
 
 func main() {
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	if err := logging.Setup(logLevel, os.Getenv("LOG_FORMAT")); err != nil {
+		slog.Error("Failed to set up logging", logging.Error(err))
+		os.Exit(1)
+	}
+
 	var err error
 	tmpl, err = template.ParseFS(templates, "templates/*.html")
 	if err != nil {
-		log.Fatal("parse templates:", err)
+		slog.Error("Failed to parse templates", logging.Error(err))
+		os.Exit(1)
 	}
 
 	connString := os.Getenv("XTDB_CONN_STRING")
@@ -36,13 +49,16 @@ func main() {
 
 	db, err = pgxpool.New(context.Background(), connString)
 	if err != nil {
-		log.Fatal("connect to XTDB:", err)
+		slog.Error("Failed to connect to XTDB", logging.Error(err))
+		os.Exit(1)
 	}
 	defer db.Close()
 
 	http.HandleFunc("/", handleIndex)
 	http.HandleFunc("/api/processes", handleProcesses)
 	http.HandleFunc("/api/activities/", handleProcessActivities)
+	http.HandleFunc("/api/call-tree", handleCallTree)
+	http.HandleFunc("/api/activity-tree/", handleActivityTree)
 	http.HandleFunc("/api/audit/misrouted", handleMisroutedAudit)
 	http.HandleFunc("/api/audit/counterfactual", handleCounterfactual)
 	http.HandleFunc("/api/timeline", handleTimeline)
@@ -58,8 +74,11 @@ func main() {
 		port = "3000"
 	}
 
-	log.Printf("Starting Decision Observability UI on http://localhost:%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	slog.Info("Starting Decision Observability UI", "url", "http://localhost:"+port)
+	if err := http.ListenAndServe(":"+port, nil); err != nil {
+		slog.Error("UI server stopped", logging.Error(err))
+		os.Exit(1)
+	}
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -72,7 +91,7 @@ func handleProcesses(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	rows, err := db.Query(ctx, `
-		SELECT process_instance_id, process_definition_key, business_key, state,
+		SELECT engine_id, process_instance_id, process_definition_key, business_key, state,
 			start_time, end_time, duration_millis
 		FROM fluxnova_processes
 		ORDER BY _valid_from DESC
@@ -86,17 +105,18 @@ func handleProcesses(w http.ResponseWriter, r *http.Request) {
 
 	var processes []map[string]any
 	for rows.Next() {
-		var processID, defKey, state string
+		var engineID, processID, defKey, state string
 		var businessKey *string
 		var startTime string
 		var endTime *string
 		var durationMillis *int64
 
-		if err := rows.Scan(&processID, &defKey, &businessKey, &state, &startTime, &endTime, &durationMillis); err != nil {
+		if err := rows.Scan(&engineID, &processID, &defKey, &businessKey, &state, &startTime, &endTime, &durationMillis); err != nil {
 			continue
 		}
 
 		proc := map[string]any{
+			"engine_id":              engineID,
 			"process_instance_id":    processID,
 			"process_definition_key": defKey,
 			"state":                  state,
@@ -124,14 +144,16 @@ func handleProcessActivities(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	engineID := engineParam(r)
+
 	ctx := r.Context()
 	sql := fmt.Sprintf(`
-		SELECT _id, activity_id, activity_name, activity_type, execution_id,
-			start_time, end_time, duration_millis, canceled, process_variables
+		SELECT activity_instance_id, activity_id, activity_name, activity_type, execution_id,
+			start_time, end_time, duration_millis, canceled
 		FROM fluxnova_events
-		WHERE process_instance_id = %s
+		WHERE engine_id = %s AND process_instance_id = %s
 		ORDER BY start_time
-	`, quote(processID))
+	`, quote(engineID), quote(processID))
 
 	rows, err := db.Query(ctx, sql)
 	if err != nil {
@@ -143,12 +165,12 @@ func handleProcessActivities(w http.ResponseWriter, r *http.Request) {
 	var activities []map[string]any
 	for rows.Next() {
 		var id, activityID, activityType, executionID, startTime string
-		var activityName, endTime, processVars *string
+		var activityName, endTime *string
 		var durationMillis *int64
 		var canceled bool
 
 		if err := rows.Scan(&id, &activityID, &activityName, &activityType, &executionID,
-			&startTime, &endTime, &durationMillis, &canceled, &processVars); err != nil {
+			&startTime, &endTime, &durationMillis, &canceled); err != nil {
 			continue
 		}
 
@@ -169,18 +191,199 @@ func handleProcessActivities(w http.ResponseWriter, r *http.Request) {
 		if durationMillis != nil {
 			act["duration_millis"] = *durationMillis
 		}
-		if processVars != nil {
-			var vars map[string]any
-			if json.Unmarshal([]byte(*processVars), &vars) == nil {
-				act["variables"] = vars
-			}
-		}
 		activities = append(activities, act)
 	}
+	rows.Close()
+
+	// Variables are stored once per change in fluxnova_variables rather than
+	// on every activity, so attach the current snapshot here
+	if vars := getProcessVariables(ctx, engineID, processID); vars != nil {
+		for _, act := range activities {
+			act["variables"] = vars
+		}
+	}
 
 	jsonResponse(w, activities)
 }
 
+func getProcessVariables(ctx context.Context, engineID, processID string) map[string]any {
+	sql := fmt.Sprintf(`
+		SELECT variables FROM fluxnova_variables
+		WHERE engine_id = %s AND process_instance_id = %s
+	`, quote(engineID), quote(processID))
+
+	var vars map[string]any
+	if err := db.QueryRow(ctx, sql).Scan(&vars); err != nil {
+		return nil
+	}
+	return vars
+}
+
+// handleCallTree returns the process instances called from (or calling) the
+// instance with a business key in the engine_id query parameter's engine,
+// nested by call activity, with each instance's state as of the as_of query
+// parameter (default now)
+func handleCallTree(w http.ResponseWriter, r *http.Request) {
+	businessKey := r.URL.Query().Get("business_key")
+	if businessKey == "" {
+		jsonError(w, fmt.Errorf("business_key required"), 400)
+		return
+	}
+	asOf := time.Now().UTC()
+	if s := r.URL.Query().Get("as_of"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			jsonError(w, fmt.Errorf("as_of must be RFC3339: %w", err), 400)
+			return
+		}
+		asOf = t.UTC()
+	}
+	engineID := engineParam(r)
+
+	ctx := r.Context()
+	validTime := "FOR VALID_TIME AS OF TIMESTAMP " + quote(asOf.Format(time.RFC3339Nano))
+
+	// Every instance sharing a root with an instance that has the business key
+	sql := fmt.Sprintf(`
+		SELECT process_instance_id, process_definition_key, business_key,
+			parent_process_instance_id, state, start_time, end_time
+		FROM fluxnova_processes %s
+		WHERE engine_id = %s AND root_process_instance_id IN (
+			SELECT root_process_instance_id FROM fluxnova_processes %s
+			WHERE engine_id = %s AND business_key = %s
+		)
+		ORDER BY start_time
+	`, validTime, quote(engineID), validTime, quote(engineID), quote(businessKey))
+
+	rows, err := db.Query(ctx, sql)
+	if err != nil {
+		jsonError(w, err, 500)
+		return
+	}
+	defer rows.Close()
+
+	nodes := map[string]map[string]any{}
+	parents := map[string]string{}
+	var order []string
+	for rows.Next() {
+		var processID, defKey, state, startTime string
+		var bk, parentID, endTime *string
+
+		if err := rows.Scan(&processID, &defKey, &bk, &parentID, &state, &startTime, &endTime); err != nil {
+			continue
+		}
+
+		// Process records are valid from their start time, so an instance
+		// that ended after as_of was still running then
+		if endTime != nil {
+			if ended, err := time.Parse(time.RFC3339Nano, *endTime); err == nil && ended.After(asOf) {
+				state = "ACTIVE"
+				endTime = nil
+			}
+		}
+
+		node := map[string]any{
+			"process_instance_id":    processID,
+			"process_definition_key": defKey,
+			"state":                  state,
+			"start_time":             startTime,
+			"children":               []map[string]any{},
+		}
+		if bk != nil {
+			node["business_key"] = *bk
+		}
+		if endTime != nil {
+			node["end_time"] = *endTime
+		}
+		nodes[processID] = node
+		if parentID != nil {
+			parents[processID] = *parentID
+		}
+		order = append(order, processID)
+	}
+	rows.Close()
+
+	if len(order) == 0 {
+		jsonError(w, fmt.Errorf("no process instance with business key %s as of %s", businessKey, asOf.Format(time.RFC3339)), 404)
+		return
+	}
+
+	// Link each child to the call activity that started it
+	ids := make([]string, len(order))
+	for i, id := range order {
+		ids[i] = quote(id)
+	}
+	sql = fmt.Sprintf(`
+		SELECT called_process_instance_id, activity_id, activity_name
+		FROM fluxnova_events %s
+		WHERE engine_id = %s AND called_process_instance_id IN (%s)
+	`, validTime, quote(engineID), strings.Join(ids, ", "))
+
+	if rows, err := db.Query(ctx, sql); err == nil {
+		for rows.Next() {
+			var calledID, activityID string
+			var activityName *string
+			if err := rows.Scan(&calledID, &activityID, &activityName); err != nil {
+				continue
+			}
+			if node, ok := nodes[calledID]; ok {
+				node["called_from_activity_id"] = activityID
+				if activityName != nil {
+					node["called_from_activity_name"] = *activityName
+				}
+			}
+		}
+		rows.Close()
+	}
+
+	var roots []map[string]any
+	for _, id := range order {
+		parent, ok := nodes[parents[id]]
+		if !ok {
+			roots = append(roots, nodes[id])
+			continue
+		}
+		parent["children"] = append(parent["children"].([]map[string]any), nodes[id])
+	}
+
+	jsonResponse(w, map[string]any{
+		"business_key": businessKey,
+		"as_of":        asOf.Format(time.RFC3339),
+		"roots":        roots,
+	})
+}
+
+// handleActivityTree returns the activity instance tree of a process as of
+// the as_of query parameter (default now)
+func handleActivityTree(w http.ResponseWriter, r *http.Request) {
+	processID := strings.TrimPrefix(r.URL.Path, "/api/activity-tree/")
+	if processID == "" {
+		jsonError(w, fmt.Errorf("process_instance_id required"), 400)
+		return
+	}
+	asOf := time.Now().UTC()
+	if s := r.URL.Query().Get("as_of"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			jsonError(w, fmt.Errorf("as_of must be RFC3339: %w", err), 400)
+			return
+		}
+		asOf = t.UTC()
+	}
+
+	tree, err := xtdb.NewClient(db).ActivityTree(r.Context(), engineParam(r), processID, asOf)
+	if err != nil {
+		jsonError(w, err, 500)
+		return
+	}
+
+	jsonResponse(w, map[string]any{
+		"process_instance_id": processID,
+		"as_of":               asOf.Format(time.RFC3339),
+		"activities":          tree,
+	})
+}
+
 func handleMisroutedAudit(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -502,6 +705,16 @@ func quote(s string) string {
 	return "'" + escaped + "'"
 }
 
+// engineParam returns the engine_id query parameter, which selects the
+// connector source whose records are read, defaulting to the single source
+// of a connector without sources
+func engineParam(r *http.Request) string {
+	if id := r.URL.Query().Get("engine_id"); id != "" {
+		return id
+	}
+	return config.DefaultSourceID
+}
+
 //go:embed static/architecture.svg
 var architectureSVG []byte
 
@@ -634,7 +847,7 @@ func handleExport(w http.ResponseWriter, r *http.Request) {
 
 func getProcessesData(ctx context.Context) ([]map[string]any, error) {
 	rows, err := db.Query(ctx, `
-		SELECT process_instance_id, process_definition_key, business_key, state,
+		SELECT engine_id, process_instance_id, process_definition_key, business_key, state,
 			start_time, end_time, duration_millis
 		FROM fluxnova_processes
 		ORDER BY _valid_from DESC
@@ -647,17 +860,18 @@ func getProcessesData(ctx context.Context) ([]map[string]any, error) {
 
 	var processes []map[string]any
 	for rows.Next() {
-		var processID, defKey, state string
+		var engineID, processID, defKey, state string
 		var businessKey *string
 		var startTime string
 		var endTime *string
 		var durationMillis *int64
 
-		if err := rows.Scan(&processID, &defKey, &businessKey, &state, &startTime, &endTime, &durationMillis); err != nil {
+		if err := rows.Scan(&engineID, &processID, &defKey, &businessKey, &state, &startTime, &endTime, &durationMillis); err != nil {
 			continue
 		}
 
 		proc := map[string]any{
+			"engine_id":              engineID,
 			"process_instance_id":    processID,
 			"process_definition_key": defKey,
 			"state":                  state,
@@ -4,9 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,6 +16,9 @@ import (
 
 	"github.com/refset/fluxnova-decision-observability/demo/workflow"
 	"github.com/refset/fluxnova-decision-observability/demo/xtdb"
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
 )
 
 const (
@@ -22,6 +26,9 @@ const (
 	LockTimeout = 30000 // 30 seconds in milliseconds
 )
 
+// httpClient authenticates to Fluxnova with the connector's credentials
+var httpClient = http.DefaultClient
+
 type ExternalTask struct {
 	ID                  string         `json:"id"`
 	WorkerID            string         `json:"workerId"`
@@ -60,9 +67,26 @@ type FailureRequest struct {
 }
 
 func main() {
-	baseURL := os.Getenv("FLUXNOVA_BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080/engine-rest"
+	// The worker shares the connector's Fluxnova settings, including
+	// FLUXNOVA_BASE_URL and the OAuth2 and TLS options
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	loader := config.NewLoader(fs)
+	fs.Parse(os.Args[1:])
+	cfg, err := loader.Read()
+	if err != nil {
+		slog.Error("Failed to load config", logging.Error(err))
+		os.Exit(1)
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		slog.Error("Failed to set up logging", logging.Error(err))
+		os.Exit(1)
+	}
+	baseURL := cfg.Fluxnova.BaseURL
+
+	httpClient, err = fluxnova.NewHTTPClient(fluxnova.AuthFromConfig(cfg.Fluxnova), 30*time.Second)
+	if err != nil {
+		slog.Error("Failed to create Fluxnova client", logging.Error(err))
+		os.Exit(1)
 	}
 
 	var activities *workflow.Activities
@@ -71,16 +95,16 @@ func main() {
 	if xtdbConnString != "" {
 		xtdbClient, err := xtdb.NewClientFromConnString(context.Background(), xtdbConnString)
 		if err != nil {
-			log.Println("Warning: Could not connect to XTDB, activities will not persist decision context:", err)
+			slog.Warn("Could not connect to XTDB, activities will not persist decision context", logging.Error(err))
 			activities = workflow.NewActivities()
 		} else {
 			defer xtdbClient.Close()
 			activities = workflow.NewActivitiesWithXTDB(xtdbClient)
-			log.Println("XTDB integration enabled - activities will persist decision context")
+			slog.Info("XTDB integration enabled - activities will persist decision context")
 		}
 	} else {
 		activities = workflow.NewActivities()
-		log.Println("XTDB_CONN_STRING not set - activities will not persist decision context")
+		slog.Info("XTDB_CONN_STRING not set - activities will not persist decision context")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -90,13 +114,11 @@ func main() {
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigCh
-		log.Println("Received shutdown signal")
+		slog.Info("Received shutdown signal")
 		cancel()
 	}()
 
-	log.Printf("Starting Fluxnova external task worker")
-	log.Printf("  Fluxnova: %s", baseURL)
-	log.Printf("  Worker ID: %s", WorkerID)
+	slog.Info("Starting Fluxnova external task worker", "base_url", baseURL, "worker_id", WorkerID)
 
 	topics := []TopicSubscription{
 		{TopicName: "analyze-sentiment", LockDuration: LockTimeout},
@@ -112,18 +134,18 @@ func main() {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Worker shutting down")
+			slog.Info("Worker shutting down")
 			return
 		case <-ticker.C:
 			tasks, err := fetchAndLock(baseURL, topics)
 			if err != nil {
-				log.Printf("Error fetching tasks: %v", err)
+				slog.Error("Failed to fetch tasks", logging.Error(err))
 				continue
 			}
 
 			for _, task := range tasks {
 				if err := handleTask(ctx, baseURL, activities, task); err != nil {
-					log.Printf("Error handling task %s: %v", task.ID, err)
+					slog.Error("Failed to handle task", "task_id", task.ID, "process_instance_id", task.ProcessInstanceID, logging.Error(err))
 					failTask(baseURL, task.ID, err.Error())
 				}
 			}
@@ -140,7 +162,7 @@ func fetchAndLock(baseURL string, topics []TopicSubscription) ([]ExternalTask, e
 	}
 
 	body, _ := json.Marshal(req)
-	resp, err := http.Post(baseURL+"/external-task/fetchAndLock", "application/json", bytes.NewReader(body))
+	resp, err := httpClient.Post(baseURL+"/external-task/fetchAndLock", "application/json", bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -160,7 +182,7 @@ func fetchAndLock(baseURL string, topics []TopicSubscription) ([]ExternalTask, e
 }
 
 func handleTask(ctx context.Context, baseURL string, activities *workflow.Activities, task ExternalTask) error {
-	log.Printf("Handling task %s (topic: %s, process: %s)", task.ID, task.TopicName, task.ProcessInstanceID)
+	slog.Info("Handling task", "task_id", task.ID, "task_topic", task.TopicName, "process_instance_id", task.ProcessInstanceID)
 
 	var result map[string]any
 
@@ -172,7 +194,7 @@ func handleTask(ctx context.Context, baseURL string, activities *workflow.Activi
 			return err
 		}
 		result = map[string]any{
-			"sentiment": map[string]any{"value": toJSON(sentiment)},
+			"sentiment": map[string]any{"value": toJSON(sentiment), "type": "Json"},
 		}
 
 	case "lookup-customer-profile":
@@ -182,7 +204,7 @@ func handleTask(ctx context.Context, baseURL string, activities *workflow.Activi
 			return err
 		}
 		result = map[string]any{
-			"customerProfile": map[string]any{"value": toJSON(profile)},
+			"customerProfile": map[string]any{"value": toJSON(profile), "type": "Json"},
 		}
 
 	case "check-churn-signals":
@@ -192,7 +214,7 @@ func handleTask(ctx context.Context, baseURL string, activities *workflow.Activi
 			return err
 		}
 		result = map[string]any{
-			"churnSignals": map[string]any{"value": toJSON(churn)},
+			"churnSignals": map[string]any{"value": toJSON(churn), "type": "Json"},
 		}
 
 	case "decide-routing":
@@ -212,7 +234,7 @@ func handleTask(ctx context.Context, baseURL string, activities *workflow.Activi
 			return err
 		}
 		result = map[string]any{
-			"routingDecision": map[string]any{"value": toJSON(routing)},
+			"routingDecision": map[string]any{"value": toJSON(routing), "type": "Json"},
 		}
 
 	case "generate-response":
@@ -231,8 +253,10 @@ func handleTask(ctx context.Context, baseURL string, activities *workflow.Activi
 		if err != nil {
 			return err
 		}
+		// A String, not Json: the human review gateway's conditions search
+		// its text, which a Spin JSON node doesn't support
 		result = map[string]any{
-			"responseDraft": map[string]any{"value": toJSON(response)},
+			"responseDraft": map[string]any{"value": toJSON(response), "type": "String"},
 		}
 
 	default:
@@ -249,7 +273,7 @@ func completeTask(baseURL, taskID string, variables map[string]any) error {
 	}
 
 	body, _ := json.Marshal(req)
-	resp, err := http.Post(baseURL+"/external-task/"+taskID+"/complete", "application/json", bytes.NewReader(body))
+	resp, err := httpClient.Post(baseURL+"/external-task/"+taskID+"/complete", "application/json", bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
@@ -260,7 +284,7 @@ func completeTask(baseURL, taskID string, variables map[string]any) error {
 		return fmt.Errorf("complete failed with status %d: %s", resp.StatusCode, string(respBody))
 	}
 
-	log.Printf("Completed task %s", taskID)
+	slog.Info("Completed task", "task_id", taskID)
 	return nil
 }
 
@@ -273,14 +297,14 @@ func failTask(baseURL, taskID, errorMessage string) {
 	}
 
 	body, _ := json.Marshal(req)
-	resp, err := http.Post(baseURL+"/external-task/"+taskID+"/failure", "application/json", bytes.NewReader(body))
+	resp, err := httpClient.Post(baseURL+"/external-task/"+taskID+"/failure", "application/json", bytes.NewReader(body))
 	if err != nil {
-		log.Printf("Failed to report failure for task %s: %v", taskID, err)
+		slog.Error("Failed to report task failure", "task_id", taskID, logging.Error(err))
 		return
 	}
 	defer resp.Body.Close()
 
-	log.Printf("Reported failure for task %s", taskID)
+	slog.Info("Reported task failure", "task_id", taskID)
 }
 
 func getStringVar(vars map[string]any, name string) string {
@@ -0,0 +1,101 @@
+package xtdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ActivityNode is an activity instance with the instances in its scope.
+// Subprocesses, event subprocesses and multi-instance bodies are scopes;
+// the instances of a multi-instance activity are children of its
+// multiInstanceBody node.
+type ActivityNode struct {
+	ID            string          `json:"id"`
+	ActivityID    string          `json:"activity_id"`
+	ActivityName  *string         `json:"activity_name,omitempty"`
+	ActivityType  string          `json:"activity_type"`
+	ExecutionID   string          `json:"execution_id"`
+	StartTime     time.Time       `json:"start_time"`
+	EndTime       *time.Time      `json:"end_time,omitempty"`
+	Active        bool            `json:"active"`
+	Canceled      bool            `json:"canceled"`
+	CompleteScope bool            `json:"complete_scope"`
+	Children      []*ActivityNode `json:"children,omitempty"`
+}
+
+// ActivityTree reconstructs the activity instance tree of a process instance
+// of the engine engineID as of a valid time from fluxnova_events. The
+// returned nodes are the activities directly in the process scope, ordered by
+// start time.
+func (c *Client) ActivityTree(ctx context.Context, engineID, processInstanceID string, asOf time.Time) ([]*ActivityNode, error) {
+	sql := fmt.Sprintf(`
+		SELECT activity_instance_id, parent_activity_instance_id, activity_id, activity_name, activity_type,
+			execution_id, start_time, end_time, canceled, complete_scope
+		FROM fluxnova_events FOR VALID_TIME AS OF TIMESTAMP %s
+		WHERE engine_id = %s AND process_instance_id = %s
+		ORDER BY start_time
+	`, quote(asOf.UTC().Format(time.RFC3339Nano)), quote(engineID), quote(processInstanceID))
+
+	rows, err := c.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("query activity instances: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := map[string]*ActivityNode{}
+	parents := map[string]string{}
+	var order []*ActivityNode
+	for rows.Next() {
+		var node ActivityNode
+		var parentID, endTime *string
+		var startTime string
+		var canceled, completeScope *bool
+
+		if err := rows.Scan(&node.ID, &parentID, &node.ActivityID, &node.ActivityName, &node.ActivityType,
+			&node.ExecutionID, &startTime, &endTime, &canceled, &completeScope); err != nil {
+			return nil, fmt.Errorf("scan activity instance: %w", err)
+		}
+
+		node.StartTime, err = time.Parse(time.RFC3339Nano, startTime)
+		if err != nil {
+			return nil, fmt.Errorf("activity instance %s: %w", node.ID, err)
+		}
+
+		// Records are valid from the activity's start, so an activity that
+		// ended after asOf was still active then
+		node.Active = true
+		if endTime != nil {
+			end, err := time.Parse(time.RFC3339Nano, *endTime)
+			if err != nil {
+				return nil, fmt.Errorf("activity instance %s: %w", node.ID, err)
+			}
+			if !end.After(asOf) {
+				node.EndTime = &end
+				node.Active = false
+				node.Canceled = canceled != nil && *canceled
+				node.CompleteScope = completeScope != nil && *completeScope
+			}
+		}
+
+		nodes[node.ID] = &node
+		if parentID != nil {
+			parents[node.ID] = *parentID
+		}
+		order = append(order, &node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query activity instances: %w", err)
+	}
+
+	// Activities in the process scope have the process instance as parent
+	var roots []*ActivityNode
+	for _, node := range order {
+		if parent, ok := nodes[parents[node.ID]]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots, nil
+}
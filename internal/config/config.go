@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"time"
 
@@ -9,30 +11,356 @@ import (
 
 type Config struct {
 	Fluxnova FluxnovaConfig `yaml:"fluxnova"`
+	Sources  []SourceConfig `yaml:"sources"`
 	Kafka    KafkaConfig    `yaml:"kafka"`
+	Sink     SinkConfig     `yaml:"sink"`
 	Pipeline PipelineConfig `yaml:"pipeline"`
+	Tracing  TracingConfig  `yaml:"tracing"`
 	LogLevel string         `yaml:"log_level"`
+	// LogFormat is json or text
+	LogFormat string `yaml:"log_format"`
 }
 
 type FluxnovaConfig struct {
 	BaseURL  string `yaml:"base_url"`
 	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Password Secret `yaml:"password"`
+	// DateFormat is the engine's dateFormat as a Java SimpleDateFormat
+	// pattern, if it isn't the default yyyy-MM-dd'T'HH:mm:ss.SSSZ
+	DateFormat string `yaml:"date_format"`
+	// OAuth2 replaces Basic auth with client credentials bearer tokens
+	OAuth2 OAuth2Config `yaml:"oauth2"`
+	TLS    TLSConfig    `yaml:"tls"`
+}
+
+// OAuth2Config configures the OAuth2 client credentials grant used to
+// authenticate to the engine. It is enabled by setting token_url.
+type OAuth2Config struct {
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret Secret   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+	// Audience is sent for identity providers that require it (e.g. Auth0)
+	Audience string `yaml:"audience"`
+}
+
+// TLSConfig configures TLS to the engine and its token endpoint
+type TLSConfig struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are a PEM client certificate for mTLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// Source types
+const (
+	// SourceTypePoll polls the engine's REST history API
+	SourceTypePoll = "poll"
+	// SourceTypeWebhook receives history events POSTed by an engine-side
+	// history event handler
+	SourceTypeWebhook = "webhook"
+	// SourceTypeKafka reads history events from a Kafka topic fed by an
+	// engine-side history event handler
+	SourceTypeKafka = "kafka"
+	// SourceTypeDatabase reads the ACT_HI_* history tables directly from the
+	// engine's Postgres database
+	SourceTypeDatabase = "database"
+)
+
+// SourceConfig describes one Fluxnova engine (or tenant subset of an engine)
+// captured by the connector
+type SourceConfig struct {
+	ID              string `yaml:"id"`
+	Type            string `yaml:"type"`
+	FluxnovaConfig  `yaml:",inline"`
+	TenantIDs       []string      `yaml:"tenant_ids"`
+	WithoutTenantID bool          `yaml:"without_tenant_id"`
+	PollInterval    time.Duration `yaml:"poll_interval"`
+	CheckpointFile  string        `yaml:"checkpoint_file"`
+	Stream          StreamConfig  `yaml:"stream"`
+	DatabaseURL     Secret        `yaml:"database_url"`
+	// EngineTimeZone is the IANA time zone of the engine's JVM, which a
+	// database source reads history timestamps in. Empty is UTC.
+	EngineTimeZone string `yaml:"engine_time_zone"`
+}
+
+// StreamConfig configures how a webhook or kafka source receives pushed
+// history events
+type StreamConfig struct {
+	// ListenAddr and Path are where a webhook source accepts events
+	ListenAddr string `yaml:"listen_addr"`
+	Path       string `yaml:"path"`
+	// Token must be sent by the engine as a bearer token. A webhook source
+	// without one is refused unless AllowUnauthenticated is set.
+	Token                Secret `yaml:"token"`
+	AllowUnauthenticated bool   `yaml:"allow_unauthenticated"`
+	// Topic and GroupID are read by a kafka source, using the Kafka brokers
+	Topic   string `yaml:"topic"`
+	GroupID string `yaml:"group_id"`
 }
 
 type KafkaConfig struct {
 	Brokers        []string `yaml:"brokers"`
 	EventsTopic    string   `yaml:"events_topic"`
 	ProcessesTopic string   `yaml:"processes_topic"`
+	VariablesTopic string   `yaml:"variables_topic"`
+	PurgesTopic    string   `yaml:"purges_topic"`
+	BreachesTopic  string   `yaml:"breaches_topic"`
+}
+
+// Sink types
+const (
+	// SinkTypeKafka publishes records to the Kafka topics
+	SinkTypeKafka = "kafka"
+	// SinkTypeNDJSON writes records as JSON lines to stdout or a file
+	SinkTypeNDJSON = "ndjson"
+	// SinkTypeParquet writes records as Parquet files partitioned by date
+	// and process definition
+	SinkTypeParquet = "parquet"
+	// SinkTypeWebhook POSTs records to the webhook endpoints only
+	SinkTypeWebhook = "webhook"
+)
+
+// SinkConfig selects where the pipeline writes its records. The file sinks
+// use the Kafka topic names to tell the record streams apart.
+type SinkConfig struct {
+	Type    string        `yaml:"type"`
+	NDJSON  NDJSONConfig  `yaml:"ndjson"`
+	Parquet ParquetConfig `yaml:"parquet"`
+	// Webhook endpoints receive records in addition to the sink of any
+	// other type
+	Webhook WebhookConfig `yaml:"webhook"`
+}
+
+// NDJSONConfig configures the ndjson sink
+type NDJSONConfig struct {
+	// Path is the file written; empty or "-" writes to stdout
+	Path string `yaml:"path"`
+	// MaxSizeMB rotates the file before it grows past this size; 0 never
+	// rotates
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxFiles is how many rotated files are kept; 0 keeps them all
+	MaxFiles int `yaml:"max_files"`
+}
+
+// ParquetConfig configures the parquet sink. Records are buffered per
+// partition and written as a new file when MaxRows are buffered or
+// FlushInterval has passed since the partition's first buffered record.
+// Checkpoints are saved once the records before them are written, so they
+// can lag polling by up to FlushInterval.
+type ParquetConfig struct {
+	Dir           string        `yaml:"dir"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	MaxRows       int           `yaml:"max_rows"`
+}
+
+// WebhookConfig lists the HTTP endpoints records are POSTed to
+type WebhookConfig struct {
+	Endpoints []WebhookEndpoint `yaml:"endpoints"`
+}
+
+// WebhookEndpoint is an HTTP endpoint receiving the records that pass its
+// filters. Zero values of the batching and retry settings use the defaults.
+type WebhookEndpoint struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Secret, if set, signs each request body with HMAC-SHA256
+	Secret Secret `yaml:"secret"`
+	// Topics are globs of the topics whose records are sent; all if empty
+	Topics []string `yaml:"topics"`
+	// When is a boolean expression over the record's fields selecting the
+	// records to send; all if empty
+	When string `yaml:"when"`
+	// BatchSize records are sent per request as a JSON array, or a single
+	// record as an object if 1 (the default). A partial batch is sent once
+	// BatchInterval (default 5s) has passed since its first record.
+	BatchSize     int           `yaml:"batch_size"`
+	BatchInterval time.Duration `yaml:"batch_interval"`
+	// MaxRetries (default 5) retries with exponential backoff on network
+	// errors, 429 and 5xx responses
+	MaxRetries int           `yaml:"max_retries"`
+	Timeout    time.Duration `yaml:"timeout"`
 }
 
 type PipelineConfig struct {
-	PollInterval time.Duration `yaml:"poll_interval"`
-	BatchSize    int           `yaml:"batch_size"`
+	PollInterval         time.Duration         `yaml:"poll_interval"`
+	AdaptivePolling      AdaptivePollingConfig `yaml:"adaptive_polling"`
+	BatchSize            int                   `yaml:"batch_size"`
+	FetchBinaryVariables bool                  `yaml:"fetch_binary_variables"`
+	Filters              FiltersConfig         `yaml:"filters"`
+	Variables            VariablesConfig       `yaml:"variables"`
+	Transforms           []RecordTransform     `yaml:"transforms"`
+	Reconcile            ReconcileConfig       `yaml:"reconcile"`
+	SLA                  SLAConfig             `yaml:"sla"`
+}
+
+// Record kinds a transform can apply to
+const (
+	RecordProcess   = "process"
+	RecordActivity  = "activity"
+	RecordVariables = "variables"
+)
+
+// ReservedRecordFields identify a record and its version in XTDB, so a
+// transform can't set them
+var ReservedRecordFields = []string{"_id", "_valid_from", "engine_id"}
+
+// RecordTransform derives fields of published records and routes them with
+// expressions (https://expr-lang.org) evaluated against the record's fields.
+// Transforms apply in order, each seeing the fields set by earlier ones.
+type RecordTransform struct {
+	Name string `yaml:"name"`
+	// Records limits the transform to process, activity or variables
+	// records; it applies to all if empty
+	Records []string `yaml:"records"`
+	// When is a boolean expression selecting the records to transform; all
+	// if empty
+	When string `yaml:"when"`
+	// Set maps field names to expressions whose values are added to the
+	// record. Each is evaluated against the record before this transform.
+	Set map[string]string `yaml:"set"`
+	// Topic, if set, sends the record to this topic instead of its usual one
+	Topic string `yaml:"topic"`
+}
+
+// FiltersConfig selects which process instances and activities are
+// published. Process definitions are matched by key, or by key:version for
+// patterns containing a colon. Instances without a tenant or business key
+// match the empty string.
+type FiltersConfig struct {
+	ProcessDefinitions Filter `yaml:"process_definitions"`
+	Tenants            Filter `yaml:"tenants"`
+	BusinessKeys       Filter `yaml:"business_keys"`
+	// ActivityTypes filters activity records only, e.g. to leave out
+	// gateways; the process instance is still published
+	ActivityTypes Filter `yaml:"activity_types"`
+}
+
+// Filter matches values with globs. A value passes if Include is empty or it
+// matches an Include pattern, and it matches no Exclude pattern.
+type Filter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// TracingConfig controls export of process instances as OpenTelemetry
+// traces. Export is disabled unless an endpoint is set.
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP collector, e.g. http://localhost:4318
+	Endpoint string `yaml:"endpoint"`
+	// Headers are Name=value pairs sent with each export
+	Headers     []string `yaml:"headers"`
+	ServiceName string   `yaml:"service_name"`
 }
 
-func Load() (*Config, error) {
-	cfg := &Config{
+// Purge policies applied when reconciliation finds an instance was removed
+// from the engine
+const (
+	// PurgePolicyAnnotate only records the purge in the purges topic
+	PurgePolicyAnnotate = "annotate"
+	// PurgePolicyEndValidTime also deletes the process and variable records,
+	// ending their valid time
+	PurgePolicyEndValidTime = "end_valid_time"
+)
+
+// ReconcileConfig controls periodic detection of process instances removed
+// from the engine by history cleanup or deletion. Instances that finished
+// more than Retention ago are no longer checked; zero checks them forever.
+type ReconcileConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	Policy    string        `yaml:"policy"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+// AdaptivePollingConfig varies a polling source's interval with the engine's
+// activity: a full batch is followed by another poll at once, a poll that
+// finds anything by MinInterval, and empty or failed polls by an interval
+// that doubles up to MaxInterval. A zero MinInterval is the source's poll
+// interval.
+type AdaptivePollingConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MinInterval time.Duration `yaml:"min_interval"`
+	MaxInterval time.Duration `yaml:"max_interval"`
+}
+
+// SLAConfig controls detection of process instances and activities that
+// run longer than allowed, and of running instances that stopped making
+// progress. Breaches are published to the breaches topic.
+type SLAConfig struct {
+	Rules []SLARule `yaml:"rules"`
+	// StuckAfter reports running instances in which no activity started or
+	// ended for this long; 0 disables stuck detection
+	StuckAfter time.Duration `yaml:"stuck_after"`
+}
+
+// SLARule limits how long the process instances of definitions matching
+// ProcessDefinition (a glob; empty matches all) may run, or, if Activity is
+// set, how long their activities with ids matching Activity (a glob) may.
+type SLARule struct {
+	Name              string        `yaml:"name"`
+	ProcessDefinition string        `yaml:"process_definition"`
+	Activity          string        `yaml:"activity"`
+	MaxDuration       time.Duration `yaml:"max_duration"`
+}
+
+// VariablesConfig controls which process variables are published and how
+// sensitive values are redacted
+type VariablesConfig struct {
+	TokenizeKey Secret         `yaml:"tokenize_key"`
+	Rules       []VariableRule `yaml:"rules"`
+}
+
+// VariableRule applies to process definitions matching ProcessDefinition
+// (a glob; empty matches all). Variable names are matched with globs.
+type VariableRule struct {
+	ProcessDefinition string   `yaml:"process_definition"`
+	Include           []string `yaml:"include"`
+	Exclude           []string `yaml:"exclude"`
+	Hash              []string `yaml:"hash"`
+	Mask              []string `yaml:"mask"`
+	Tokenize          []string `yaml:"tokenize"`
+}
+
+// DefaultSourceID identifies the source built from the fluxnova section when
+// no sources are listed
+const DefaultSourceID = "default"
+
+// EffectiveSources returns the configured sources, or a single source built
+// from the fluxnova section if none are listed. Sources without their own
+// poll interval inherit the pipeline's.
+func (c *Config) EffectiveSources() []SourceConfig {
+	sources := c.Sources
+	if len(sources) == 0 {
+		sources = []SourceConfig{{
+			ID:             DefaultSourceID,
+			Type:           SourceTypePoll,
+			FluxnovaConfig: c.Fluxnova,
+		}}
+	}
+
+	result := make([]SourceConfig, len(sources))
+	for i, src := range sources {
+		if src.Type == "" {
+			src.Type = SourceTypePoll
+		}
+		if src.PollInterval == 0 {
+			src.PollInterval = c.Pipeline.PollInterval
+		}
+		if src.Stream.Path == "" {
+			src.Stream.Path = "/history-events"
+		}
+		if src.Stream.GroupID == "" {
+			src.Stream.GroupID = "fluxnova-cdc-" + src.ID
+		}
+		result[i] = src
+	}
+	return result
+}
+
+// setDefaults fills cfg with the built-in defaults
+func setDefaults(cfg *Config) {
+	*cfg = Config{
 		Fluxnova: FluxnovaConfig{
 			BaseURL:  "http://localhost:8080/engine-rest",
 			Username: "",
@@ -42,37 +370,54 @@ func Load() (*Config, error) {
 			Brokers:        []string{"localhost:9092"},
 			EventsTopic:    "fluxnova-events",
 			ProcessesTopic: "fluxnova-processes",
+			VariablesTopic: "fluxnova-variables",
+			PurgesTopic:    "fluxnova-purges",
+			BreachesTopic:  "fluxnova-sla-breaches",
+		},
+		Sink: SinkConfig{
+			Type:   SinkTypeKafka,
+			NDJSON: NDJSONConfig{MaxSizeMB: 100},
+			Parquet: ParquetConfig{
+				Dir:           "parquet",
+				FlushInterval: 5 * time.Minute,
+				MaxRows:       100000,
+			},
 		},
 		Pipeline: PipelineConfig{
 			PollInterval: 10 * time.Second,
-			BatchSize:    100,
+			AdaptivePolling: AdaptivePollingConfig{
+				MaxInterval: 2 * time.Minute,
+			},
+			BatchSize: 100,
+			Reconcile: ReconcileConfig{
+				Policy:    PurgePolicyAnnotate,
+				Retention: 90 * 24 * time.Hour,
+			},
+		},
+		Tracing: TracingConfig{
+			ServiceName: "fluxnova",
 		},
-		LogLevel: "info",
+		LogLevel:  "info",
+		LogFormat: "json",
 	}
+}
 
-	// Load from YAML if exists
-	if data, err := os.ReadFile("config.yaml"); err == nil {
-		if err := yaml.Unmarshal(data, cfg); err != nil {
-			return nil, err
+// loadFile reads a YAML configuration file over cfg, rejecting keys that
+// don't map to a field. A missing file is only an error if required.
+func loadFile(cfg *Config, path string, required bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
 		}
+		return err
 	}
+	defer f.Close()
 
-	// Override from environment
-	if v := os.Getenv("FLUXNOVA_BASE_URL"); v != "" {
-		cfg.Fluxnova.BaseURL = v
-	}
-	if v := os.Getenv("FLUXNOVA_USERNAME"); v != "" {
-		cfg.Fluxnova.Username = v
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("parse %s: %w", path, err)
 	}
-	if v := os.Getenv("FLUXNOVA_PASSWORD"); v != "" {
-		cfg.Fluxnova.Password = v
-	}
-	if v := os.Getenv("KAFKA_BROKERS"); v != "" {
-		cfg.Kafka.Brokers = []string{v}
-	}
-	if v := os.Getenv("LOG_LEVEL"); v != "" {
-		cfg.LogLevel = v
-	}
-
-	return cfg, nil
+	return nil
 }
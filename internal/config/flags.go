@@ -0,0 +1,233 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPath is the configuration file read when neither --config nor
+// CDC_CONFIG is given. It is optional; an explicitly given file is not.
+const DefaultPath = "config.yaml"
+
+// EnvPrefix prefixes the environment variable of every configuration field,
+// e.g. CDC_KAFKA_EVENTS_TOPIC for kafka.events_topic
+const EnvPrefix = "CDC_"
+
+// legacyEnv are the environment variables read before EnvPrefix existed.
+// They are still honored, below the prefixed name.
+var legacyEnv = map[string]string{
+	"fluxnova.base_url":               "FLUXNOVA_BASE_URL",
+	"fluxnova.username":               "FLUXNOVA_USERNAME",
+	"fluxnova.password":               "FLUXNOVA_PASSWORD",
+	"kafka.brokers":                   "KAFKA_BROKERS",
+	"pipeline.poll_interval":          "POLL_INTERVAL",
+	"pipeline.variables.tokenize_key": "VARIABLES_TOKENIZE_KEY",
+	"log_level":                       "LOG_LEVEL",
+}
+
+// Loader loads the configuration from, in increasing precedence: built-in
+// defaults, the configuration file, environment variables and command-line
+// flags. Every scalar and list field has a flag named after its YAML path
+// (--kafka.events_topic) and an environment variable (CDC_KAFKA_EVENTS_TOPIC).
+// Lists are comma-separated, and NAME_FILE reads a variable's value from a
+// file. Sources and variable rules are only read from the file.
+type Loader struct {
+	path  string
+	flags map[string]string
+
+	// resolved is the file the last Load read (or tried to)
+	resolved string
+}
+
+// NewLoader registers --config and a flag per configuration field on fs.
+// The returned loader reads the flags once fs has been parsed.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{flags: make(map[string]string)}
+	fs.StringVar(&l.path, "config", "", "configuration file (env CDC_CONFIG, default "+DefaultPath+")")
+
+	var defaults Config
+	setDefaults(&defaults)
+	walkFields(reflect.ValueOf(&defaults).Elem(), "", func(key string, field reflect.Value) {
+		usage := fmt.Sprintf("sets %s (env %s)", key, envName(key))
+		if def := formatField(field); def != "" {
+			usage += ", default " + def
+		}
+		set := func(s string) error {
+			if err := setField(reflect.New(field.Type()).Elem(), s); err != nil {
+				return err
+			}
+			l.flags[key] = s
+			return nil
+		}
+		if field.Kind() == reflect.Bool {
+			fs.BoolFunc(key, usage, set)
+		} else {
+			fs.Func(key, usage, set)
+		}
+	})
+	return l
+}
+
+// Load reads and validates the configuration
+func (l *Loader) Load() (*Config, error) {
+	cfg, err := l.Read()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// Read reads the configuration without validating it, for tools that only
+// use part of it
+func (l *Loader) Read() (*Config, error) {
+	path, required := l.path, true
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if path == "" {
+		path, required = DefaultPath, false
+	}
+	l.resolved = path
+
+	cfg := &Config{}
+	setDefaults(cfg)
+	if err := loadFile(cfg, path, required); err != nil {
+		return nil, err
+	}
+
+	var errs []string
+	walkFields(reflect.ValueOf(cfg).Elem(), "", func(key string, field reflect.Value) {
+		for _, name := range []string{legacyEnv[key], envName(key)} {
+			if name == "" {
+				continue
+			}
+			if v, ok := os.LookupEnv(name); ok && v != "" {
+				if err := setField(field, v); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+				}
+			}
+			// NAME_FILE reads the value from a file, e.g. a mounted secret
+			if path, ok := os.LookupEnv(name + "_FILE"); ok && path != "" {
+				if err := setFileField(field, path); err != nil {
+					errs = append(errs, fmt.Sprintf("%s_FILE: %v", name, err))
+				}
+			}
+		}
+		if v, ok := l.flags[key]; ok {
+			// Already checked when the flag was parsed
+			setField(field, v)
+		}
+	})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid environment:\n%s", strings.Join(errs, "\n"))
+	}
+
+	if errs := resolvePlaceholders(reflect.ValueOf(cfg).Elem(), ""); len(errs) > 0 {
+		return nil, fmt.Errorf("unresolved placeholders:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+// setFileField sets a field to the content of the file at path. String
+// fields get a ${file:} placeholder, so secrets are re-read on use; others
+// can't hold one and are parsed from the file now.
+func setFileField(field reflect.Value, path string) error {
+	if field.Kind() == reflect.String || field.Kind() == reflect.Slice {
+		return setField(field, "${file:"+path+"}")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return setField(field, strings.TrimSpace(string(data)))
+}
+
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// walkFields calls fn for each settable scalar or string list field of v,
+// keyed by its dotted YAML path. Lists of structs are skipped.
+func walkFields(v reflect.Value, prefix string, fn func(key string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		field := v.Field(i)
+
+		switch {
+		case sf.Type == reflect.TypeOf(time.Duration(0)):
+			fn(key, field)
+		case field.Kind() == reflect.Struct:
+			walkFields(field, key+".", fn)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.String:
+			continue
+		default:
+			fn(key, field)
+		}
+	}
+}
+
+// setField parses s into field according to its type
+func setField(field reflect.Value, s string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// formatField returns a field's value as it would be given in a flag
+func formatField(field reflect.Value) string {
+	if field.Kind() == reflect.Slice {
+		return strings.Join(field.Interface().([]string), ",")
+	}
+	if field.IsZero() {
+		return ""
+	}
+	return fmt.Sprint(field.Interface())
+}
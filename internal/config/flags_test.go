@@ -0,0 +1,76 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoaderReadFileEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		content string
+		check   func(*Config) bool
+		wantErr string
+	}{
+		{
+			name:    "string",
+			env:     "CDC_KAFKA_EVENTS_TOPIC_FILE",
+			content: "fluxnova-history\n",
+			check:   func(c *Config) bool { return c.Kafka.EventsTopic == "fluxnova-history" },
+		},
+		{
+			name:    "integer",
+			env:     "CDC_PIPELINE_BATCH_SIZE_FILE",
+			content: "250\n",
+			check:   func(c *Config) bool { return c.Pipeline.BatchSize == 250 },
+		},
+		{
+			name:    "invalid integer",
+			env:     "CDC_PIPELINE_BATCH_SIZE_FILE",
+			content: "lots",
+			wantErr: "CDC_PIPELINE_BATCH_SIZE_FILE",
+		},
+		{
+			name:    "missing file",
+			env:     "CDC_PIPELINE_POLL_INTERVAL_FILE",
+			wantErr: "CDC_PIPELINE_POLL_INTERVAL_FILE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "config.yaml")
+			if err := os.WriteFile(configPath, nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CDC_CONFIG", configPath)
+
+			path := filepath.Join(dir, "value")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv(tt.env, path)
+
+			cfg, err := NewLoader(flag.NewFlagSet("test", flag.ContinueOnError)).Read()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Read() = %v, want an error for %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("Read() didn't set the field from %s", tt.env)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// SecretProvider resolves the reference in a ${scheme:reference}
+// placeholder to a secret value
+type SecretProvider interface {
+	Resolve(ref string) (string, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]SecretProvider{
+		"env":  envProvider{},
+		"file": fileProvider{},
+	}
+)
+
+// RegisterSecretProvider makes ${scheme:reference} placeholders resolve
+// through p. It must be called before the configuration is loaded.
+func RegisterSecretProvider(scheme string, p SecretProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[scheme] = p
+}
+
+// envProvider resolves ${env:NAME} to the environment variable NAME
+type envProvider struct{}
+
+func (envProvider) Resolve(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return v, nil
+}
+
+// fileProvider resolves ${file:/path} to the content of the file, without a
+// trailing newline. The file is read on every resolution, so a secret
+// rewritten in place (e.g. by a Vault agent) is picked up.
+type fileProvider struct{}
+
+func (fileProvider) Resolve(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var placeholderPattern = regexp.MustCompile(`\$\{([a-z][a-z0-9_-]*):([^}]*)\}`)
+
+// Interpolate replaces every ${scheme:reference} placeholder in s with the
+// value from the scheme's provider
+func Interpolate(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var firstErr error
+	result := placeholderPattern.ReplaceAllStringFunc(s, func(match string) string {
+		m := placeholderPattern.FindStringSubmatch(match)
+		scheme, ref := m[1], m[2]
+
+		providersMu.RLock()
+		p, ok := providers[scheme]
+		providersMu.RUnlock()
+		if !ok {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("unknown secret provider %q in %s", scheme, match)
+			}
+			return match
+		}
+
+		v, err := p.Resolve(ref)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", match, err)
+		}
+		return v
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return result, nil
+}
+
+// Secret is a configuration value that may hold ${scheme:reference}
+// placeholders. Unlike other fields, which are interpolated once at load,
+// a Secret is resolved each time Value is called so rotated secrets are used
+// without a restart.
+type Secret string
+
+// Value resolves the secret's placeholders
+func (s Secret) Value() (string, error) {
+	return Interpolate(string(s))
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// resolvePlaceholders interpolates every string field of v except Secrets,
+// which are only checked to resolve, and returns one error per field that
+// doesn't
+func resolvePlaceholders(v reflect.Value, key string) []error {
+	var errs []error
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, opts, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			fieldKey := key
+			if opts != "inline" {
+				fieldKey = joinKey(key, name)
+			}
+			errs = append(errs, resolvePlaceholders(v.Field(i), fieldKey)...)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, resolvePlaceholders(v.Index(i), fmt.Sprintf("%s[%d]", key, i))...)
+		}
+	case reflect.String:
+		resolved, err := Interpolate(v.String())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		} else if v.Type() != secretType {
+			v.SetString(resolved)
+		}
+	}
+	return errs
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
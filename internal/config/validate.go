@@ -0,0 +1,350 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+)
+
+// Log levels accepted in log_level
+var logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+// Validate checks the configuration for values the connector can't run with
+// and returns every problem found, each naming the offending field
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if !logLevels[c.LogLevel] {
+		add("log_level", "must be one of debug, info, warn, error, got %q", c.LogLevel)
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		add("log_format", "must be json or text, got %q", c.LogFormat)
+	}
+
+	// Kafka, needed by the kafka sink and kafka sources
+	kafkaSink := c.Sink.Type == SinkTypeKafka
+	kafkaSource := slices.ContainsFunc(c.EffectiveSources(), func(src SourceConfig) bool {
+		return src.Type == SourceTypeKafka
+	})
+	if kafkaSink || kafkaSource {
+		if len(c.Kafka.Brokers) == 0 {
+			add("kafka.brokers", "at least one broker is required")
+		}
+		for i, broker := range c.Kafka.Brokers {
+			if _, _, err := net.SplitHostPort(broker); err != nil {
+				add(fmt.Sprintf("kafka.brokers[%d]", i), "must be host:port, got %q", broker)
+			}
+		}
+	}
+	topics := map[string]string{}
+	if kafkaSink {
+		for _, t := range []struct{ field, topic string }{
+			{"kafka.events_topic", c.Kafka.EventsTopic},
+			{"kafka.processes_topic", c.Kafka.ProcessesTopic},
+			{"kafka.variables_topic", c.Kafka.VariablesTopic},
+			{"kafka.purges_topic", c.Kafka.PurgesTopic},
+			{"kafka.breaches_topic", c.Kafka.BreachesTopic},
+		} {
+			if t.topic == "" {
+				add(t.field, "is required")
+				continue
+			}
+			if other, ok := topics[t.topic]; ok {
+				add(t.field, "topic %q is also used for %s", t.topic, other)
+				continue
+			}
+			topics[t.topic] = t.field
+		}
+	}
+
+	// Sink
+	switch c.Sink.Type {
+	case SinkTypeKafka, SinkTypeNDJSON:
+	case SinkTypeParquet:
+		if c.Sink.Parquet.Dir == "" {
+			add("sink.parquet.dir", "is required for the parquet sink")
+		}
+	case SinkTypeWebhook:
+		if len(c.Sink.Webhook.Endpoints) == 0 {
+			add("sink.webhook.endpoints", "at least one endpoint is required for the webhook sink")
+		}
+	default:
+		add("sink.type", "must be one of %s, %s, %s, %s, got %q",
+			SinkTypeKafka, SinkTypeNDJSON, SinkTypeParquet, SinkTypeWebhook, c.Sink.Type)
+	}
+	if c.Sink.NDJSON.MaxSizeMB < 0 {
+		add("sink.ndjson.max_size_mb", "must not be negative")
+	}
+	if c.Sink.NDJSON.MaxFiles < 0 {
+		add("sink.ndjson.max_files", "must not be negative")
+	}
+	if c.Sink.Parquet.FlushInterval <= 0 {
+		add("sink.parquet.flush_interval", "must be positive")
+	}
+	if c.Sink.Parquet.MaxRows <= 0 {
+		add("sink.parquet.max_rows", "must be positive")
+	}
+	for i, ep := range c.Sink.Webhook.Endpoints {
+		field := fmt.Sprintf("sink.webhook.endpoints[%d]", i)
+		if err := validateBaseURL(ep.URL); err != nil {
+			add(field+".url", "%v", err)
+		}
+		for _, pattern := range ep.Topics {
+			if _, err := path.Match(pattern, ""); err != nil {
+				add(field+".topics", "invalid pattern %q: %v", pattern, err)
+			}
+		}
+		if ep.When != "" {
+			if _, err := expr.Compile(ep.When, expr.AsBool()); err != nil {
+				add(field+".when", "%v", err)
+			}
+		}
+		if ep.BatchSize < 0 {
+			add(field+".batch_size", "must not be negative")
+		}
+		if ep.BatchInterval < 0 {
+			add(field+".batch_interval", "must not be negative")
+		}
+		if ep.MaxRetries < 0 {
+			add(field+".max_retries", "must not be negative")
+		}
+		if ep.Timeout < 0 {
+			add(field+".timeout", "must not be negative")
+		}
+	}
+
+	// Pipeline
+	if c.Pipeline.PollInterval <= 0 {
+		add("pipeline.poll_interval", "must be positive, got %s", c.Pipeline.PollInterval)
+	}
+	if ap := c.Pipeline.AdaptivePolling; ap.Enabled {
+		if ap.MinInterval < 0 {
+			add("pipeline.adaptive_polling.min_interval", "must not be negative, got %s", ap.MinInterval)
+		}
+		if ap.MaxInterval <= 0 {
+			add("pipeline.adaptive_polling.max_interval", "must be positive, got %s", ap.MaxInterval)
+		} else if ap.MaxInterval < ap.MinInterval {
+			add("pipeline.adaptive_polling.max_interval", "must not be below min_interval %s, got %s", ap.MinInterval, ap.MaxInterval)
+		}
+	}
+	if c.Pipeline.BatchSize <= 0 {
+		add("pipeline.batch_size", "must be positive, got %d", c.Pipeline.BatchSize)
+	}
+	if c.Pipeline.Reconcile.Interval < 0 {
+		add("pipeline.reconcile.interval", "must not be negative, got %s", c.Pipeline.Reconcile.Interval)
+	}
+	if c.Pipeline.Reconcile.Retention < 0 {
+		add("pipeline.reconcile.retention", "must not be negative, got %s", c.Pipeline.Reconcile.Retention)
+	}
+	switch c.Pipeline.Reconcile.Policy {
+	case PurgePolicyAnnotate, PurgePolicyEndValidTime:
+	default:
+		add("pipeline.reconcile.policy", "must be %s or %s, got %q", PurgePolicyAnnotate, PurgePolicyEndValidTime, c.Pipeline.Reconcile.Policy)
+	}
+
+	// Tracing
+	if c.Tracing.Endpoint != "" {
+		if err := validateBaseURL(c.Tracing.Endpoint); err != nil {
+			add("tracing.endpoint", "%v", err)
+		}
+	}
+	for i, header := range c.Tracing.Headers {
+		if name, _, ok := strings.Cut(header, "="); !ok || strings.TrimSpace(name) == "" {
+			add(fmt.Sprintf("tracing.headers[%d]", i), "must be Name=value, got %q", header)
+		}
+	}
+
+	// Filters
+	for _, f := range []struct {
+		field  string
+		filter Filter
+	}{
+		{"pipeline.filters.process_definitions", c.Pipeline.Filters.ProcessDefinitions},
+		{"pipeline.filters.tenants", c.Pipeline.Filters.Tenants},
+		{"pipeline.filters.business_keys", c.Pipeline.Filters.BusinessKeys},
+		{"pipeline.filters.activity_types", c.Pipeline.Filters.ActivityTypes},
+	} {
+		for _, pattern := range append(f.filter.Include, f.filter.Exclude...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				add(f.field, "invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+
+	// Variable rules
+	needsKey := false
+	for i, rule := range c.Pipeline.Variables.Rules {
+		field := fmt.Sprintf("pipeline.variables.rules[%d]", i)
+		patterns := [][]string{{rule.ProcessDefinition}, rule.Include, rule.Exclude, rule.Hash, rule.Mask, rule.Tokenize}
+		for _, list := range patterns {
+			for _, pattern := range list {
+				if _, err := path.Match(pattern, ""); err != nil {
+					add(field, "invalid pattern %q: %v", pattern, err)
+				}
+			}
+		}
+		if len(rule.Tokenize) > 0 {
+			needsKey = true
+		}
+	}
+	if needsKey && c.Pipeline.Variables.TokenizeKey == "" {
+		add("pipeline.variables.tokenize_key", "is required when rules use tokenize")
+	}
+
+	// Record transforms
+	for i, t := range c.Pipeline.Transforms {
+		field := fmt.Sprintf("pipeline.transforms[%d]", i)
+		for _, kind := range t.Records {
+			switch kind {
+			case RecordProcess, RecordActivity, RecordVariables:
+			default:
+				add(field+".records", "must be %s, %s or %s, got %q", RecordProcess, RecordActivity, RecordVariables, kind)
+			}
+		}
+		if t.When != "" {
+			if _, err := expr.Compile(t.When, expr.AsBool()); err != nil {
+				add(field+".when", "%v", err)
+			}
+		}
+		for name, e := range t.Set {
+			if slices.Contains(ReservedRecordFields, name) {
+				add(field+".set."+name, "can't set the reserved field %s", name)
+				continue
+			}
+			if _, err := expr.Compile(e); err != nil {
+				add(field+".set."+name, "%v", err)
+			}
+		}
+		if len(t.Set) == 0 && t.Topic == "" {
+			add(field, "sets no fields and has no topic")
+		}
+	}
+
+	// SLA rules
+	rules := map[string]bool{}
+	for i, rule := range c.Pipeline.SLA.Rules {
+		field := fmt.Sprintf("pipeline.sla.rules[%d]", i)
+		if rule.Name == "" {
+			add(field+".name", "is required")
+		} else if rules[rule.Name] {
+			add(field+".name", "duplicate rule name %q", rule.Name)
+		}
+		rules[rule.Name] = true
+		for _, p := range []struct{ field, pattern string }{
+			{"process_definition", rule.ProcessDefinition},
+			{"activity", rule.Activity},
+		} {
+			if _, err := path.Match(p.pattern, ""); err != nil {
+				add(field+"."+p.field, "invalid pattern %q: %v", p.pattern, err)
+			}
+		}
+		if rule.MaxDuration <= 0 {
+			add(field+".max_duration", "must be positive, got %s", rule.MaxDuration)
+		}
+	}
+	if c.Pipeline.SLA.StuckAfter < 0 {
+		add("pipeline.sla.stuck_after", "must not be negative, got %s", c.Pipeline.SLA.StuckAfter)
+	}
+
+	// Sources
+	ids := map[string]bool{}
+	checkpoints := map[string]string{}
+	for i, src := range c.EffectiveSources() {
+		field := fmt.Sprintf("sources[%d]", i)
+		if len(c.Sources) == 0 {
+			field = "fluxnova"
+		}
+
+		if src.ID == "" {
+			add(field+".id", "is required")
+		} else if ids[src.ID] {
+			add(field+".id", "duplicate source id %q", src.ID)
+		}
+		ids[src.ID] = true
+
+		if src.PollInterval < 0 {
+			add(field+".poll_interval", "must not be negative, got %s", src.PollInterval)
+		}
+		if src.CheckpointFile != "" {
+			if other, ok := checkpoints[src.CheckpointFile]; ok {
+				add(field+".checkpoint_file", "%s is also used by source %s", src.CheckpointFile, other)
+			}
+			checkpoints[src.CheckpointFile] = src.ID
+		}
+		if src.BaseURL != "" {
+			if err := validateBaseURL(src.BaseURL); err != nil {
+				add(field+".base_url", "%v", err)
+			}
+		}
+
+		if src.OAuth2.TokenURL != "" {
+			if err := validateBaseURL(src.OAuth2.TokenURL); err != nil {
+				add(field+".oauth2.token_url", "%v", err)
+			}
+			if src.OAuth2.ClientID == "" {
+				add(field+".oauth2.client_id", "is required with oauth2.token_url")
+			}
+		}
+		if (src.TLS.CertFile == "") != (src.TLS.KeyFile == "") {
+			add(field+".tls", "cert_file and key_file must be set together")
+		}
+
+		switch src.Type {
+		case SourceTypePoll:
+			if src.BaseURL == "" {
+				add(field+".base_url", "is required for a poll source")
+			}
+		case SourceTypeDatabase:
+			if src.DatabaseURL == "" {
+				add(field+".database_url", "is required for a database source")
+			}
+			if _, err := time.LoadLocation(src.EngineTimeZone); err != nil {
+				add(field+".engine_time_zone", "%v", err)
+			}
+		case SourceTypeWebhook:
+			if src.Stream.ListenAddr == "" {
+				add(field+".stream.listen_addr", "is required for a webhook source")
+			} else if _, _, err := net.SplitHostPort(src.Stream.ListenAddr); err != nil {
+				add(field+".stream.listen_addr", "must be host:port or :port, got %q", src.Stream.ListenAddr)
+			}
+			if src.Stream.Token == "" && !src.Stream.AllowUnauthenticated {
+				add(field+".stream.token", "is required for a webhook source unless stream.allow_unauthenticated is set")
+			}
+		case SourceTypeKafka:
+			if src.Stream.Topic == "" {
+				add(field+".stream.topic", "is required for a kafka source")
+			} else if output, ok := topics[src.Stream.Topic]; ok {
+				add(field+".stream.topic", "topic %q is the connector's own %s", src.Stream.Topic, output)
+			}
+		default:
+			add(field+".type", "must be one of %s, %s, %s, %s, got %q",
+				SourceTypePoll, SourceTypeDatabase, SourceTypeWebhook, SourceTypeKafka, src.Type)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateBaseURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("must be an http or https URL, got %q", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("has no host: %q", s)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		// want are the fields expected in the error, none if valid
+		want []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *Config) {},
+		},
+		{
+			name: "log level and format",
+			modify: func(c *Config) {
+				c.LogLevel = "trace"
+				c.LogFormat = "xml"
+			},
+			want: []string{"log_level", "log_format"},
+		},
+		{
+			name:   "broker without port",
+			modify: func(c *Config) { c.Kafka.Brokers = []string{"kafka"} },
+			want:   []string{"kafka.brokers[0]"},
+		},
+		{
+			name:   "duplicate topic",
+			modify: func(c *Config) { c.Kafka.PurgesTopic = c.Kafka.EventsTopic },
+			want:   []string{"kafka.purges_topic"},
+		},
+		{
+			name: "kafka settings unused by the ndjson sink",
+			modify: func(c *Config) {
+				c.Sink.Type = SinkTypeNDJSON
+				c.Kafka.Brokers = nil
+				c.Kafka.PurgesTopic = c.Kafka.EventsTopic
+			},
+		},
+		{
+			name: "brokers for a kafka source",
+			modify: func(c *Config) {
+				c.Sink.Type = SinkTypeNDJSON
+				c.Kafka.Brokers = nil
+				c.Sources = []SourceConfig{
+					{ID: "stream", Type: SourceTypeKafka, Stream: StreamConfig{Topic: "history"}},
+				}
+			},
+			want: []string{"kafka.brokers"},
+		},
+		{
+			name:   "unknown sink",
+			modify: func(c *Config) { c.Sink.Type = "s3" },
+			want:   []string{"sink.type"},
+		},
+		{
+			name: "webhook sink without endpoints",
+			modify: func(c *Config) {
+				c.Sink.Type = SinkTypeWebhook
+			},
+			want: []string{"sink.webhook.endpoints"},
+		},
+		{
+			name: "webhook endpoint",
+			modify: func(c *Config) {
+				c.Sink.Webhook.Endpoints = []WebhookEndpoint{{
+					URL:    "ftp://example.com",
+					Topics: []string{"["},
+					When:   "state ==",
+				}}
+			},
+			want: []string{
+				"sink.webhook.endpoints[0].url",
+				"sink.webhook.endpoints[0].topics",
+				"sink.webhook.endpoints[0].when",
+			},
+		},
+		{
+			name: "adaptive polling bounds",
+			modify: func(c *Config) {
+				c.Pipeline.AdaptivePolling = AdaptivePollingConfig{Enabled: true, MinInterval: time.Minute, MaxInterval: time.Second}
+			},
+			want: []string{"pipeline.adaptive_polling.max_interval"},
+		},
+		{
+			name: "reconcile",
+			modify: func(c *Config) {
+				c.Pipeline.Reconcile = ReconcileConfig{Interval: -time.Second, Policy: "drop", Retention: -time.Hour}
+			},
+			want: []string{"pipeline.reconcile.interval", "pipeline.reconcile.policy", "pipeline.reconcile.retention"},
+		},
+		{
+			name: "tracing",
+			modify: func(c *Config) {
+				c.Tracing.Endpoint = "localhost:4318"
+				c.Tracing.Headers = []string{"no-value"}
+			},
+			want: []string{"tracing.endpoint", "tracing.headers[0]"},
+		},
+		{
+			name:   "filter pattern",
+			modify: func(c *Config) { c.Pipeline.Filters.Tenants.Exclude = []string{"[a"} },
+			want:   []string{"pipeline.filters.tenants"},
+		},
+		{
+			name: "tokenize without key",
+			modify: func(c *Config) {
+				c.Pipeline.Variables.Rules = []VariableRule{{Tokenize: []string{"customerId"}}}
+			},
+			want: []string{"pipeline.variables.tokenize_key"},
+		},
+		{
+			name: "transforms",
+			modify: func(c *Config) {
+				c.Pipeline.Transforms = []RecordTransform{
+					{Records: []string{"purge"}, When: "1 +", Set: map[string]string{"x": "("}},
+					{},
+					{Set: map[string]string{"_id": `"x"`, "engine_id": `"us"`, "region": `"eu"`}},
+				}
+			},
+			want: []string{
+				"pipeline.transforms[0].records",
+				"pipeline.transforms[0].when",
+				"pipeline.transforms[0].set.x",
+				"pipeline.transforms[1]",
+				"pipeline.transforms[2].set._id",
+				"pipeline.transforms[2].set.engine_id",
+			},
+		},
+		{
+			name: "sla rules",
+			modify: func(c *Config) {
+				c.Pipeline.SLA.Rules = []SLARule{
+					{Name: "review", MaxDuration: time.Hour},
+					{Name: "review", Activity: "[", MaxDuration: 0},
+				}
+			},
+			want: []string{"pipeline.sla.rules[1].name", "pipeline.sla.rules[1].activity", "pipeline.sla.rules[1].max_duration"},
+		},
+		{
+			name: "duplicate sources",
+			modify: func(c *Config) {
+				c.Sources = []SourceConfig{
+					{ID: "eu", Type: SourceTypePoll, FluxnovaConfig: FluxnovaConfig{BaseURL: "http://eu/engine-rest"}, CheckpointFile: "cp"},
+					{ID: "eu", Type: SourceTypePoll, FluxnovaConfig: FluxnovaConfig{BaseURL: "http://us/engine-rest"}, CheckpointFile: "cp"},
+				}
+			},
+			want: []string{"sources[1].id", "sources[1].checkpoint_file"},
+		},
+		{
+			name: "source types",
+			modify: func(c *Config) {
+				c.Sources = []SourceConfig{
+					{ID: "rest", Type: SourceTypePoll},
+					{ID: "db", Type: SourceTypeDatabase, EngineTimeZone: "Mars/Olympus"},
+					{ID: "hook", Type: SourceTypeWebhook, Stream: StreamConfig{ListenAddr: "8090"}},
+					{ID: "stream", Type: SourceTypeKafka, Stream: StreamConfig{Topic: c.Kafka.EventsTopic}},
+				}
+			},
+			want: []string{
+				"sources[0].base_url",
+				"sources[1].database_url",
+				"sources[1].engine_time_zone",
+				"sources[2].stream.listen_addr",
+				"sources[2].stream.token",
+				"sources[3].stream.topic",
+			},
+		},
+		{
+			name: "unauthenticated webhook source",
+			modify: func(c *Config) {
+				c.Sources = []SourceConfig{
+					{ID: "hook", Type: SourceTypeWebhook, Stream: StreamConfig{ListenAddr: ":8090", AllowUnauthenticated: true}},
+				}
+			},
+		},
+		{
+			name: "oauth2 and tls",
+			modify: func(c *Config) {
+				c.Fluxnova.OAuth2.TokenURL = "https://idp/token"
+				c.Fluxnova.TLS.CertFile = "client.pem"
+			},
+			want: []string{"fluxnova.oauth2.client_id", "fluxnova.tls"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			setDefaults(&cfg)
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want errors for %v", tt.want)
+			}
+			for _, field := range tt.want {
+				if !strings.Contains(err.Error(), field+": ") {
+					t.Errorf("Validate() = %v, want an error for %s", err, field)
+				}
+			}
+			if errs, ok := err.(interface{ Unwrap() []error }); !ok || len(errs.Unwrap()) != len(tt.want) {
+				t.Errorf("Validate() = %v, want exactly %d errors", err, len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// WatchInterval is how often Watch checks the configuration file for changes
+const WatchInterval = 2 * time.Second
+
+// Watch reloads the configuration whenever its file changes or reload
+// receives, and calls apply with each configuration that loads and
+// validates. A configuration that doesn't is logged and ignored, leaving the
+// current one in effect. Watch returns when ctx is cancelled.
+func (l *Loader) Watch(ctx context.Context, reload <-chan os.Signal, apply func(*Config)) {
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+
+	last := l.stat()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			slog.Info("Received SIGHUP, reloading configuration")
+		case <-ticker.C:
+			// The file is stat'ed rather than watched so a Kubernetes
+			// ConfigMap, which is swapped through a symlink, is noticed
+			current := l.stat()
+			if current == last {
+				continue
+			}
+			last = current
+			slog.Info("Configuration file changed, reloading", "path", l.resolved)
+		}
+
+		cfg, err := l.Load()
+		if err != nil {
+			slog.Error("Configuration reload failed, keeping the current configuration", logging.Error(err))
+			continue
+		}
+		apply(cfg)
+	}
+}
+
+// fileState identifies a version of the configuration file
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func (l *Loader) stat() fileState {
+	info, err := os.Stat(l.resolved)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// Diff returns the dotted YAML path of every field that differs between two
+// configurations, e.g. pipeline.poll_interval or sources[1].base_url. Lists
+// of strings are compared whole, and lists of structs that differ in length
+// are reported by their own path.
+func Diff(a, b *Config) []string {
+	var changed []string
+	diffValues(reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem(), "", &changed)
+	return changed
+}
+
+func diffValues(a, b reflect.Value, key string, changed *[]string) {
+	switch {
+	case a.Kind() == reflect.Struct && a.Type() != reflect.TypeOf(time.Duration(0)):
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, opts, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			fieldKey := key
+			if opts != "inline" {
+				fieldKey = joinKey(key, name)
+			}
+			diffValues(a.Field(i), b.Field(i), fieldKey, changed)
+		}
+	case a.Kind() == reflect.Slice && a.Type().Elem().Kind() == reflect.Struct:
+		if a.Len() != b.Len() {
+			*changed = append(*changed, key)
+			return
+		}
+		for i := 0; i < a.Len(); i++ {
+			diffValues(a.Index(i), b.Index(i), fmt.Sprintf("%s[%d]", key, i), changed)
+		}
+	case a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0:
+		// A list left out and an empty list are the same
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*changed = append(*changed, key)
+		}
+	}
+}
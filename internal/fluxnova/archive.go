@@ -0,0 +1,317 @@
+package fluxnova
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// History archives record the engine's raw REST responses so a capture can
+// be replayed later, e.g. to reproduce a problem or feed another sink. An
+// archive is gzip-compressed JSON, one entry per line. Restarting a
+// recording appends a new gzip member, which readers see as one stream.
+
+// ArchiveEntry is one line of a history archive: either a source's
+// checkpoint when recording started, or a request and its response.
+// Request paths are relative to the source's base URL.
+type ArchiveEntry struct {
+	Source     string     `json:"source"`
+	Time       time.Time  `json:"time"`
+	Checkpoint *time.Time `json:"checkpoint,omitempty"`
+
+	Method  string          `json:"method,omitempty"`
+	Path    string          `json:"path,omitempty"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Status  int             `json:"status,omitempty"`
+	// Body holds a JSON response; Data holds any other response
+	Body json.RawMessage `json:"body,omitempty"`
+	Data []byte          `json:"data,omitempty"`
+}
+
+// ErrNotRecorded is returned by a replaying client for requests the archive
+// has no (further) response for
+var ErrNotRecorded = errors.New("request not recorded in archive")
+
+// ArchiveWriter appends entries to a history archive. It is safe for
+// concurrent use by several sources.
+type ArchiveWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+}
+
+// CreateArchive opens the archive at path for recording, appending to it
+// if it exists. Responses are recorded before variable rules apply, so a new
+// archive is only readable by its owner.
+func CreateArchive(path string) (*ArchiveWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &ArchiveWriter{file: f, gz: gz, enc: json.NewEncoder(gz)}, nil
+}
+
+// write appends an entry and flushes it, so an archive is readable up to
+// the last complete entry if the connector stops abruptly
+func (w *ArchiveWriter) write(entry ArchiveEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(entry); err != nil {
+		return err
+	}
+	return w.gz.Flush()
+}
+
+// RecordCheckpoint records the checkpoint a source starts polling from,
+// which a replay starts from too
+func (w *ArchiveWriter) RecordCheckpoint(sourceID string, checkpoint *time.Time) error {
+	return w.write(ArchiveEntry{Source: sourceID, Time: time.Now().UTC(), Checkpoint: checkpoint})
+}
+
+// Close finishes the archive
+func (w *ArchiveWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.gz.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Record makes c write each request it sends and the response to w as
+// entries of source sourceID. Authentication headers aren't recorded.
+func (c *Client) Record(w *ArchiveWriter, sourceID string) {
+	c.httpClient.Transport = &recordingTransport{
+		base:     c.httpClient.Transport,
+		archive:  w,
+		source:   sourceID,
+		basePath: basePath(c.baseURL),
+	}
+}
+
+// recordingTransport wraps the client's authenticating transport, so it
+// sees requests before credentials are added
+type recordingTransport struct {
+	base     http.RoundTripper
+	archive  *ArchiveWriter
+	source   string
+	basePath string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := ArchiveEntry{
+		Source: t.source,
+		Time:   time.Now().UTC(),
+		Method: req.Method,
+		Path:   relativePath(req.URL, t.basePath),
+	}
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		entry.Request = compactJSON(data)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	entry.Status = resp.StatusCode
+	if json.Valid(data) {
+		entry.Body = compactJSON(data)
+	} else {
+		entry.Data = data
+	}
+	if err := t.archive.write(entry); err != nil {
+		return nil, fmt.Errorf("record response: %w", err)
+	}
+	return resp, nil
+}
+
+// Archive is a history archive read for replay
+type Archive struct {
+	checkpoints map[string]*time.Time
+	sources     []string
+
+	mu sync.Mutex
+	// Recorded responses per source and request, in recording order
+	responses map[string]map[string][]ArchiveEntry
+}
+
+// ReadArchive reads the archive at path
+func ReadArchive(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer gz.Close()
+
+	a := &Archive{
+		checkpoints: make(map[string]*time.Time),
+		responses:   make(map[string]map[string][]ArchiveEntry),
+	}
+	dec := json.NewDecoder(gz)
+	for line := 1; ; line++ {
+		var entry ArchiveEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The recording was cut off mid-entry; replay what's complete
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, line, err)
+		}
+
+		requests, ok := a.responses[entry.Source]
+		if !ok {
+			requests = make(map[string][]ArchiveEntry)
+			a.responses[entry.Source] = requests
+			a.sources = append(a.sources, entry.Source)
+		}
+		if entry.Method == "" {
+			// A later recording session resumes where the earlier one
+			// stopped, so the first checkpoint is where replay starts
+			if _, ok := a.checkpoints[entry.Source]; !ok {
+				a.checkpoints[entry.Source] = entry.Checkpoint
+			}
+			continue
+		}
+		key := requestKey(entry.Method, entry.Path, entry.Request)
+		requests[key] = append(requests[key], entry)
+	}
+	return a, nil
+}
+
+// Sources returns the ids of the sources recorded in the archive, in the
+// order they first appear
+func (a *Archive) Sources() []string {
+	return a.sources
+}
+
+// Checkpoint returns the checkpoint source sourceID was recorded from, nil
+// if it started from the beginning of history
+func (a *Archive) Checkpoint(sourceID string) *time.Time {
+	return a.checkpoints[sourceID]
+}
+
+// Replay makes c answer requests with the responses recorded for source
+// sourceID instead of calling the engine. Each recorded response is
+// returned once, in recording order; requests with no response left fail
+// with ErrNotRecorded.
+func (c *Client) Replay(a *Archive, sourceID string) {
+	c.httpClient.Transport = &replayTransport{
+		archive:  a,
+		source:   sourceID,
+		basePath: basePath(c.baseURL),
+	}
+}
+
+type replayTransport struct {
+	archive  *Archive
+	source   string
+	basePath string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = compactJSON(data)
+	}
+	path := relativePath(req.URL, t.basePath)
+	key := requestKey(req.Method, path, body)
+
+	t.archive.mu.Lock()
+	requests := t.archive.responses[t.source]
+	queue := requests[key]
+	if len(queue) == 0 {
+		t.archive.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.Method, path)
+	}
+	entry := queue[0]
+	requests[key] = queue[1:]
+	t.archive.mu.Unlock()
+
+	data := []byte(entry.Body)
+	if entry.Body == nil {
+		data = entry.Data
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Status, http.StatusText(entry.Status)),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+func requestKey(method, path string, body []byte) string {
+	return method + " " + path + " " + string(body)
+}
+
+// basePath returns the path of the client's base URL, which recorded paths
+// are relative to so an archive can be replayed against another host
+func basePath(baseURL string) string {
+	u, err := neturl.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.EscapedPath(), "/")
+}
+
+func relativePath(u *neturl.URL, basePath string) string {
+	return strings.TrimPrefix(u.RequestURI(), basePath)
+}
+
+// compactJSON returns data without insignificant whitespace if it is JSON,
+// so recorded and replayed request bodies compare equal
+func compactJSON(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,124 @@
+package fluxnova
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchiveRecordAndReplay(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/engine-rest/history/process-instance":
+			if r.Method == "POST" {
+				w.Write([]byte(`[{"id": "pi-1", "state": "COMPLETED"}]`))
+				return
+			}
+			w.Write([]byte(`[
+				{"id": "pi-1", "processDefinitionKey": "loan", "startTime": "2024-05-01T09:00:00.000+0000", "state": "ACTIVE"}
+			]`))
+		case "/engine-rest/history/variable-instance/v-1/data":
+			w.Write([]byte{0x00, 0xff, 'p', 'd', 'f'})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer engine.Close()
+
+	path := filepath.Join(t.TempDir(), "history.ndjson.gz")
+	checkpoint := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// Record a session, then a second one appended to the same archive
+	var recorded []HistoricProcessInstance
+	for session := range 2 {
+		w, err := CreateArchive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp := checkpoint.Add(time.Duration(session) * time.Hour)
+		if err := w.RecordCheckpoint("eu", &cp); err != nil {
+			t.Fatal(err)
+		}
+		client := NewClient(engine.URL+"/engine-rest", "demo", "demo")
+		client.Record(w, "eu")
+
+		instances, err := client.GetHistoricProcessInstances(ProcessInstanceQuery{StartedAfter: &checkpoint, MaxResults: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, instances...)
+		if session == 0 {
+			if _, err := client.FindExistingProcessInstances([]string{"pi-1"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.GetHistoricVariableInstanceData("v-1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.GetHistoricActivityInstances("missing"); err == nil {
+				t.Fatal("query for a missing path succeeded")
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("archive mode = %o, want 600", mode)
+	}
+
+	archive, err := ReadArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := archive.Sources(); !reflect.DeepEqual(got, []string{"eu"}) {
+		t.Errorf("Sources() = %v, want [eu]", got)
+	}
+	if got := archive.Checkpoint("eu"); got == nil || !got.Equal(checkpoint) {
+		t.Errorf("Checkpoint() = %v, want the first session's %v", got, checkpoint)
+	}
+
+	// Replay against another base URL, which the engine is never asked
+	client := NewClient("http://replay.invalid/engine-rest", "", "")
+	client.Replay(archive, "eu")
+
+	var replayed []HistoricProcessInstance
+	for range 2 {
+		instances, err := client.GetHistoricProcessInstances(ProcessInstanceQuery{StartedAfter: &checkpoint, MaxResults: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		replayed = append(replayed, instances...)
+	}
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("replayed %+v, want %+v", replayed, recorded)
+	}
+	existing, err := client.FindExistingProcessInstances([]string{"pi-1"})
+	if err != nil || len(existing) != 1 || existing[0].State != "COMPLETED" {
+		t.Errorf("FindExistingProcessInstances() = %+v, %v", existing, err)
+	}
+	data, err := client.GetHistoricVariableInstanceData("v-1")
+	if err != nil || string(data) != "\x00\xffpdf" {
+		t.Errorf("GetHistoricVariableInstanceData() = %q, %v", data, err)
+	}
+	if _, err := client.GetHistoricActivityInstances("missing"); err == nil || errors.Is(err, ErrNotRecorded) {
+		t.Errorf("recorded failure replayed as %v, want the engine's error", err)
+	}
+
+	// Each response is replayed once
+	if _, err := client.GetHistoricProcessInstances(ProcessInstanceQuery{StartedAfter: &checkpoint, MaxResults: 10}); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("third query error = %v, want ErrNotRecorded", err)
+	}
+	if _, err := client.FindExistingProcessInstances([]string{"pi-2"}); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("unrecorded request body error = %v, want ErrNotRecorded", err)
+	}
+}
@@ -0,0 +1,249 @@
+package fluxnova
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthConfig configures authentication and TLS for requests to the engine
+type AuthConfig struct {
+	// Basic auth, used when no token URL is set. The password is fetched
+	// for every request so a rotated password is picked up.
+	Username string
+	Password func() (string, error)
+
+	// OAuth2 client credentials. Tokens are cached until shortly before
+	// they expire, or until the engine rejects one.
+	TokenURL     string
+	ClientID     string
+	ClientSecret func() (string, error)
+	Scopes       []string
+	Audience     string
+
+	// CAFile adds a CA bundle to the system roots; CertFile and KeyFile
+	// are a client certificate for mTLS
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// tokenExpiryMargin is how long before expiry a cached token is refreshed
+const tokenExpiryMargin = 30 * time.Second
+
+// NewHTTPClient returns an HTTP client that authenticates every request as
+// configured. It can be used for any engine API, not just history.
+func NewHTTPClient(cfg AuthConfig, timeout time.Duration) (*http.Client, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" || cfg.CertFile != "" {
+		tlsConfig, err := newTLSConfig(cfg.CAFile, cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		base.TLSClientConfig = tlsConfig
+	}
+
+	transport := &authTransport{base: base, username: cfg.Username, password: cfg.Password}
+	if cfg.TokenURL != "" {
+		transport.tokens = &tokenSource{
+			client:       &http.Client{Transport: base, Timeout: timeout},
+			tokenURL:     cfg.TokenURL,
+			clientID:     cfg.ClientID,
+			clientSecret: cfg.ClientSecret,
+			scopes:       cfg.Scopes,
+			audience:     cfg.Audience,
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+func newTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no certificates", caFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if certFile != "" {
+		// Reload the client certificate on each handshake so a renewed
+		// certificate is used without a restart
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("load client certificate: %w", err)
+			}
+			return &cert, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// authTransport adds Basic or bearer credentials to requests
+type authTransport struct {
+	base     http.RoundTripper
+	username string
+	password func() (string, error)
+	tokens   *tokenSource
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	if t.tokens != nil {
+		token, err := t.tokens.token()
+		if err != nil {
+			closeBody(req)
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized {
+			// The token may have been revoked; fetch a new one next time
+			t.tokens.invalidate(token)
+		}
+		return resp, err
+	}
+
+	if t.username != "" && t.password != nil {
+		password, err := t.password()
+		if err != nil {
+			closeBody(req)
+			return nil, fmt.Errorf("resolve Fluxnova password: %w", err)
+		}
+		if password != "" {
+			req.SetBasicAuth(t.username, password)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// closeBody closes the body of a request that won't be sent, as RoundTrip
+// must even when it fails
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// tokenSource fetches and caches OAuth2 client credentials tokens
+type tokenSource struct {
+	client       *http.Client
+	tokenURL     string
+	clientID     string
+	clientSecret func() (string, error)
+	scopes       []string
+	audience     string
+
+	mu      sync.Mutex
+	current string
+	expiry  time.Time
+}
+
+func (s *tokenSource) token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != "" && (s.expiry.IsZero() || time.Now().Before(s.expiry)) {
+		return s.current, nil
+	}
+
+	token, expiresIn, err := s.fetch()
+	if err != nil {
+		return "", err
+	}
+	s.current = token
+	s.expiry = time.Time{}
+	if expiresIn > 0 {
+		margin := tokenExpiryMargin
+		if margin > expiresIn/2 {
+			margin = expiresIn / 2
+		}
+		s.expiry = time.Now().Add(expiresIn - margin)
+	}
+	return token, nil
+}
+
+// invalidate drops the cached token if it is still the given one
+func (s *tokenSource) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == token {
+		s.current = ""
+	}
+}
+
+func (s *tokenSource) fetch() (string, time.Duration, error) {
+	secret := ""
+	if s.clientSecret != nil {
+		var err error
+		if secret, err = s.clientSecret(); err != nil {
+			return "", 0, fmt.Errorf("resolve OAuth2 client secret: %w", err)
+		}
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	if s.audience != "" {
+		form.Set("audience", s.audience)
+	}
+
+	req, err := http.NewRequest("POST", s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(secret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("fetch OAuth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", 0, fmt.Errorf("OAuth2 token endpoint error %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", 0, fmt.Errorf("decode OAuth2 token: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", 0, fmt.Errorf("OAuth2 token endpoint returned no access_token")
+	}
+	if result.TokenType != "" && !strings.EqualFold(result.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported OAuth2 token type %q", result.TokenType)
+	}
+	return result.AccessToken, time.Duration(result.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,254 @@
+package fluxnova
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer is a fake OAuth2 token endpoint issuing token-1, token-2, ...
+func tokenServer(t *testing.T, fetches *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "connector" || secret != "s3cret" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "history" {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		n := fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 3600}`, n)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func oauthConfig(tokenURL string) AuthConfig {
+	return AuthConfig{
+		TokenURL:     tokenURL,
+		ClientID:     "connector",
+		ClientSecret: func() (string, error) { return "s3cret", nil },
+		Scopes:       []string{"history"},
+	}
+}
+
+func TestOAuth2TokenIsCached(t *testing.T) {
+	var fetches atomic.Int32
+	tokens := tokenServer(t, &fetches)
+
+	var seen []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+	}))
+	defer api.Close()
+
+	client, err := NewHTTPClient(oauthConfig(tokens.URL), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		resp, err := client.Get(api.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("token fetched %d times, want 1", got)
+	}
+	for _, auth := range seen {
+		if auth != "Bearer token-1" {
+			t.Errorf("Authorization = %q, want Bearer token-1", auth)
+		}
+	}
+}
+
+func TestOAuth2TokenRefreshedAfter401(t *testing.T) {
+	var fetches atomic.Int32
+	tokens := tokenServer(t, &fetches)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first token has been revoked
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	client, err := NewHTTPClient(oauthConfig(tokens.URL), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []int
+	for range 2 {
+		resp, err := client.Get(api.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+	}
+
+	if statuses[0] != http.StatusUnauthorized || statuses[1] != http.StatusOK {
+		t.Errorf("statuses = %v, want [401 200]", statuses)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("token fetched %d times, want 2", got)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCA, certFile, keyFile := writeClientCertificate(t, dir)
+
+	api := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	api.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCA}
+	api.StartTLS()
+	defer api.Close()
+
+	// The server's self-signed certificate is trusted through CAFile
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", api.Certificate().Raw)
+
+	client, err := NewHTTPClient(AuthConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "connector" {
+		t.Errorf("server saw client certificate %q, want connector", body)
+	}
+
+	// Without the client certificate the handshake fails
+	client, err = NewHTTPClient(AuthConfig{CAFile: caFile}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(api.URL); err == nil {
+		resp.Body.Close()
+		t.Error("request without a client certificate succeeded")
+	}
+}
+
+func TestRoundTripClosesBodyOnError(t *testing.T) {
+	client, err := NewHTTPClient(AuthConfig{
+		Username: "demo",
+		Password: func() (string, error) { return "", errors.New("vault unavailable") },
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := &trackedBody{Reader: strings.NewReader("{}")}
+	req, err := http.NewRequest("POST", "http://engine.invalid/history", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("request succeeded without a password")
+	}
+	if !body.closed {
+		t.Error("request body not closed")
+	}
+}
+
+type trackedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+// writeClientCertificate creates a CA and a client certificate it signed for
+// "connector", returning the CA pool and the certificate and key files
+func writeClientCertificate(t *testing.T, dir string) (*x509.CertPool, string, string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "connector"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", certDER)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
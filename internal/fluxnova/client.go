@@ -1,11 +1,13 @@
 package fluxnova
 
 import (
-	"encoding/base64"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"strings"
 	"time"
 )
 
@@ -13,22 +15,35 @@ import (
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
-	authHeader string
+	layouts    DateLayouts
 }
 
-// NewClient creates a new Fluxnova client
+// clientTimeout bounds each request to the engine
+const clientTimeout = 30 * time.Second
+
+// NewClient creates a new Fluxnova client using Basic auth
 func NewClient(baseURL, username, password string) *Client {
-	c := &Client{
-		baseURL:    baseURL,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-	}
-	if username != "" && password != "" {
-		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
-		c.authHeader = "Basic " + auth
-	}
+	// Without TLS files NewHTTPClient can't fail
+	c, _ := NewClientWithAuth(baseURL, AuthConfig{
+		Username: username,
+		Password: func() (string, error) { return password, nil },
+	})
 	return c
 }
 
+// NewClientWithAuth creates a new Fluxnova client authenticating as
+// configured
+func NewClientWithAuth(baseURL string, auth AuthConfig) (*Client, error) {
+	httpClient, err := NewHTTPClient(auth, clientTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		baseURL:    baseURL,
+		httpClient: httpClient,
+	}, nil
+}
+
 // HistoricProcessInstance represents a completed or running process instance
 type HistoricProcessInstance struct {
 	ID                       string  `json:"id"`
@@ -37,13 +52,14 @@ type HistoricProcessInstance struct {
 	ProcessDefinitionKey     string  `json:"processDefinitionKey"`
 	ProcessDefinitionName    *string `json:"processDefinitionName"`
 	ProcessDefinitionVersion int     `json:"processDefinitionVersion"`
-	StartTime                string  `json:"startTime"`
-	EndTime                  *string `json:"endTime"`
+	StartTime                Time    `json:"startTime"`
+	EndTime                  *Time   `json:"endTime"`
 	DurationInMillis         *int64  `json:"durationInMillis"`
 	StartUserID              *string `json:"startUserId"`
 	StartActivityID          string  `json:"startActivityId"`
 	DeleteReason             *string `json:"deleteReason"`
 	SuperProcessInstanceID   *string `json:"superProcessInstanceId"`
+	RootProcessInstanceID    *string `json:"rootProcessInstanceId"`
 	TenantID                 *string `json:"tenantId"`
 	State                    string  `json:"state"`
 }
@@ -63,8 +79,8 @@ type HistoricActivityInstance struct {
 	Assignee                 *string `json:"assignee"`
 	CalledProcessInstanceID  *string `json:"calledProcessInstanceId"`
 	CalledCaseInstanceID     *string `json:"calledCaseInstanceId"`
-	StartTime                string  `json:"startTime"`
-	EndTime                  *string `json:"endTime"`
+	StartTime                Time    `json:"startTime"`
+	EndTime                  *Time   `json:"endTime"`
 	DurationInMillis         *int64  `json:"durationInMillis"`
 	Canceled                 bool    `json:"canceled"`
 	CompleteScope            bool    `json:"completeScope"`
@@ -73,18 +89,19 @@ type HistoricActivityInstance struct {
 
 // HistoricVariableInstance represents a process variable value
 type HistoricVariableInstance struct {
-	ID                  string  `json:"id"`
-	Name                string  `json:"name"`
-	Type                string  `json:"type"`
-	Value               any     `json:"value"`
-	ProcessDefinitionID string  `json:"processDefinitionId"`
-	ProcessInstanceID   string  `json:"processInstanceId"`
-	ExecutionID         *string `json:"executionId"`
-	ActivityInstanceID  *string `json:"activityInstanceId"`
-	TaskID              *string `json:"taskId"`
-	CreateTime          string  `json:"createTime"`
-	State               string  `json:"state"`
-	TenantID            *string `json:"tenantId"`
+	ID                  string         `json:"id"`
+	Name                string         `json:"name"`
+	Type                string         `json:"type"`
+	Value               any            `json:"value"`
+	ValueInfo           map[string]any `json:"valueInfo"`
+	ProcessDefinitionID string         `json:"processDefinitionId"`
+	ProcessInstanceID   string         `json:"processInstanceId"`
+	ExecutionID         *string        `json:"executionId"`
+	ActivityInstanceID  *string        `json:"activityInstanceId"`
+	TaskID              *string        `json:"taskId"`
+	CreateTime          Time           `json:"createTime"`
+	State               string         `json:"state"`
+	TenantID            *string        `json:"tenantId"`
 }
 
 // HistoricDetail represents a detailed audit log entry
@@ -96,22 +113,63 @@ type HistoricDetail struct {
 	ExecutionID         *string `json:"executionId"`
 	ActivityInstanceID  *string `json:"activityInstanceId"`
 	TaskID              *string `json:"taskId"`
-	Time                string  `json:"time"`
+	Time                Time    `json:"time"`
 	TenantID            *string `json:"tenantId"`
 	// For variable updates
-	VariableName         *string `json:"variableName,omitempty"`
-	VariableInstanceID   *string `json:"variableInstanceId,omitempty"`
-	VariableType         *string `json:"variableType,omitempty"`
-	Value                any     `json:"value,omitempty"`
-	Revision             *int    `json:"revision,omitempty"`
-	InitialValue         *bool   `json:"initial,omitempty"`
+	VariableName       *string        `json:"variableName,omitempty"`
+	VariableInstanceID *string        `json:"variableInstanceId,omitempty"`
+	VariableType       *string        `json:"variableType,omitempty"`
+	Value              any            `json:"value,omitempty"`
+	ValueInfo          map[string]any `json:"valueInfo,omitempty"`
+	Revision           *int           `json:"revision,omitempty"`
+	InitialValue       *bool          `json:"initial,omitempty"`
+}
+
+// ProcessInstanceQuery filters a historic process instance query
+type ProcessInstanceQuery struct {
+	StartedAfter    *time.Time
+	StartedBefore   *time.Time
+	FinishedAfter   *time.Time
+	Unfinished      bool
+	TenantIDs       []string
+	WithoutTenantID bool
+	// ProcessDefinitionKeys, if set, restricts the query to these keys;
+	// ExcludedProcessDefinitionKeys are left out
+	ProcessDefinitionKeys         []string
+	ExcludedProcessDefinitionKeys []string
+	FirstResult                   int
+	MaxResults                    int
 }
 
 // GetHistoricProcessInstances queries historic process instances
-func (c *Client) GetHistoricProcessInstances(startedAfter *time.Time, maxResults int) ([]HistoricProcessInstance, error) {
-	url := fmt.Sprintf("%s/history/process-instance?sortBy=startTime&sortOrder=asc&maxResults=%d", c.baseURL, maxResults)
-	if startedAfter != nil {
-		url += "&startedAfter=" + startedAfter.Format("2006-01-02T15:04:05.000-0700")
+func (c *Client) GetHistoricProcessInstances(q ProcessInstanceQuery) ([]HistoricProcessInstance, error) {
+	url := fmt.Sprintf("%s/history/process-instance?sortBy=startTime&sortOrder=asc&maxResults=%d", c.baseURL, q.MaxResults)
+	if q.FirstResult > 0 {
+		url += fmt.Sprintf("&firstResult=%d", q.FirstResult)
+	}
+	if q.StartedAfter != nil {
+		url += "&startedAfter=" + c.formatQueryTime(*q.StartedAfter)
+	}
+	if q.StartedBefore != nil {
+		url += "&startedBefore=" + c.formatQueryTime(*q.StartedBefore)
+	}
+	if q.FinishedAfter != nil {
+		url += "&finishedAfter=" + c.formatQueryTime(*q.FinishedAfter)
+	}
+	if q.Unfinished {
+		url += "&unfinished=true"
+	}
+	if len(q.TenantIDs) > 0 {
+		url += "&tenantIdIn=" + neturl.QueryEscape(strings.Join(q.TenantIDs, ","))
+	}
+	if q.WithoutTenantID {
+		url += "&withoutTenantId=true"
+	}
+	if len(q.ProcessDefinitionKeys) > 0 {
+		url += "&processDefinitionKeyIn=" + neturl.QueryEscape(strings.Join(q.ProcessDefinitionKeys, ","))
+	}
+	if len(q.ExcludedProcessDefinitionKeys) > 0 {
+		url += "&processDefinitionKeyNotIn=" + neturl.QueryEscape(strings.Join(q.ExcludedProcessDefinitionKeys, ","))
 	}
 
 	var result []HistoricProcessInstance
@@ -121,6 +179,23 @@ func (c *Client) GetHistoricProcessInstances(startedAfter *time.Time, maxResults
 	return result, nil
 }
 
+// FindExistingProcessInstances returns the historic process instances of the
+// given ids that still exist in the engine's history
+func (c *Client) FindExistingProcessInstances(ids []string) ([]HistoricProcessInstance, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	url := fmt.Sprintf("%s/history/process-instance?maxResults=%d", c.baseURL, len(ids))
+	body := map[string]any{"processInstanceIds": ids}
+
+	var result []HistoricProcessInstance
+	if err := c.post(url, body, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // GetHistoricActivityInstances queries historic activity instances for a process
 func (c *Client) GetHistoricActivityInstances(processInstanceID string) ([]HistoricActivityInstance, error) {
 	url := fmt.Sprintf("%s/history/activity-instance?processInstanceId=%s&sortBy=startTime&sortOrder=asc", c.baseURL, processInstanceID)
@@ -132,9 +207,51 @@ func (c *Client) GetHistoricActivityInstances(processInstanceID string) ([]Histo
 	return result, nil
 }
 
+// ActivityInstanceQuery filters a historic activity instance query across
+// process instances
+type ActivityInstanceQuery struct {
+	ActivityID      string
+	StartedBefore   *time.Time
+	Unfinished      bool
+	TenantIDs       []string
+	WithoutTenantID bool
+	FirstResult     int
+	MaxResults      int
+}
+
+// FindHistoricActivityInstances queries historic activity instances of any
+// process instance
+func (c *Client) FindHistoricActivityInstances(q ActivityInstanceQuery) ([]HistoricActivityInstance, error) {
+	url := fmt.Sprintf("%s/history/activity-instance?sortBy=startTime&sortOrder=asc&maxResults=%d", c.baseURL, q.MaxResults)
+	if q.FirstResult > 0 {
+		url += fmt.Sprintf("&firstResult=%d", q.FirstResult)
+	}
+	if q.ActivityID != "" {
+		url += "&activityId=" + neturl.QueryEscape(q.ActivityID)
+	}
+	if q.StartedBefore != nil {
+		url += "&startedBefore=" + c.formatQueryTime(*q.StartedBefore)
+	}
+	if q.Unfinished {
+		url += "&unfinished=true"
+	}
+	if len(q.TenantIDs) > 0 {
+		url += "&tenantIdIn=" + neturl.QueryEscape(strings.Join(q.TenantIDs, ","))
+	}
+	if q.WithoutTenantID {
+		url += "&withoutTenantId=true"
+	}
+
+	var result []HistoricActivityInstance
+	if err := c.get(url, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // GetHistoricVariableInstances queries historic variable instances for a process
 func (c *Client) GetHistoricVariableInstances(processInstanceID string) ([]HistoricVariableInstance, error) {
-	url := fmt.Sprintf("%s/history/variable-instance?processInstanceId=%s", c.baseURL, processInstanceID)
+	url := fmt.Sprintf("%s/history/variable-instance?processInstanceId=%s&deserializeValues=false", c.baseURL, processInstanceID)
 
 	var result []HistoricVariableInstance
 	if err := c.get(url, &result); err != nil {
@@ -143,6 +260,30 @@ func (c *Client) GetHistoricVariableInstances(processInstanceID string) ([]Histo
 	return result, nil
 }
 
+// GetHistoricVariableInstanceData fetches the binary content of a Bytes or File variable
+func (c *Client) GetHistoricVariableInstanceData(variableInstanceID string) ([]byte, error) {
+	url := fmt.Sprintf("%s/history/variable-instance/%s/data", c.baseURL, variableInstanceID)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Fluxnova API error %d: %s", resp.StatusCode, string(body))
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
 // GetHistoricDetails queries historic details (audit log) for a process
 func (c *Client) GetHistoricDetails(processInstanceID string) ([]HistoricDetail, error) {
 	url := fmt.Sprintf("%s/history/detail?processInstanceId=%s&sortBy=time&sortOrder=asc", c.baseURL, processInstanceID)
@@ -154,16 +295,57 @@ func (c *Client) GetHistoricDetails(processInstanceID string) ([]HistoricDetail,
 	return result, nil
 }
 
+// SetDateLayouts sets the engine's date formats, used to parse history
+// timestamps and to format times in history queries
+func (c *Client) SetDateLayouts(layouts DateLayouts) {
+	c.layouts = layouts
+}
+
+// DateLayouts returns the engine's date formats
+func (c *Client) DateLayouts() DateLayouts {
+	return c.layouts
+}
+
+// formatQueryTime formats a time for use as a history query parameter
+func (c *Client) formatQueryTime(t time.Time) string {
+	return neturl.QueryEscape(t.Format(c.layouts.QueryLayout()))
+}
+
 func (c *Client) get(url string, result any) error {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
 
-	if c.authHeader != "" {
-		req.Header.Set("Authorization", c.authHeader)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Fluxnova API error %d: %s", resp.StatusCode, string(body))
+	}
+
+	return c.decode(resp.Body, result)
+}
+
+func (c *Client) post(url string, body any, result any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
 	}
+
 	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -176,7 +358,16 @@ func (c *Client) get(url string, result any) error {
 		return fmt.Errorf("Fluxnova API error %d: %s", resp.StatusCode, string(body))
 	}
 
-	return json.NewDecoder(resp.Body).Decode(result)
+	return c.decode(resp.Body, result)
+}
+
+// decode reads a JSON response into result, parsing its timestamps with the
+// engine's date formats
+func (c *Client) decode(r io.Reader, result any) error {
+	if err := json.NewDecoder(r).Decode(result); err != nil {
+		return err
+	}
+	return c.layouts.Resolve(result)
 }
 
 // Ping checks connectivity to Fluxnova
@@ -186,9 +377,6 @@ func (c *Client) Ping() error {
 	if err != nil {
 		return err
 	}
-	if c.authHeader != "" {
-		req.Header.Set("Authorization", c.authHeader)
-	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -0,0 +1,34 @@
+package fluxnova
+
+import "github.com/refset/fluxnova-decision-observability/internal/config"
+
+// NewClientFromConfig creates a REST client for the engine authenticating
+// with cfg's Basic auth or OAuth2 credentials and TLS settings
+func NewClientFromConfig(cfg config.FluxnovaConfig) (*Client, error) {
+	client, err := NewClientWithAuth(cfg.BaseURL, AuthFromConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	layouts, err := NewDateLayouts(cfg.DateFormat)
+	if err != nil {
+		return nil, err
+	}
+	client.SetDateLayouts(layouts)
+	return client, nil
+}
+
+// AuthFromConfig maps cfg to the client's authentication settings
+func AuthFromConfig(cfg config.FluxnovaConfig) AuthConfig {
+	return AuthConfig{
+		Username:     cfg.Username,
+		Password:     cfg.Password.Value,
+		TokenURL:     cfg.OAuth2.TokenURL,
+		ClientID:     cfg.OAuth2.ClientID,
+		ClientSecret: cfg.OAuth2.ClientSecret.Value,
+		Scopes:       cfg.OAuth2.Scopes,
+		Audience:     cfg.OAuth2.Audience,
+		CAFile:       cfg.TLS.CAFile,
+		CertFile:     cfg.TLS.CertFile,
+		KeyFile:      cfg.TLS.KeyFile,
+	}
+}
@@ -0,0 +1,477 @@
+package fluxnova
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// Activity instance states stored in ACT_HI_ACTINST.ACT_INST_STATE_
+const (
+	activityStateScopeComplete = 1
+	activityStateCanceled      = 2
+)
+
+// DBPoller reads process history directly from the engine's Postgres
+// database (ACT_HI_* tables) instead of the REST API. New process instances
+// are found with a keyset query on (START_TIME_, ID_), and the activities,
+// variables and variable update times of a whole batch are read with one
+// query each.
+type DBPoller struct {
+	pool      *pgxpool.Pool
+	batchSize int
+
+	lastStartTime *time.Time
+	lastID        string
+	fetchBinary   bool
+	// location is the engine's time zone, which the ACT_HI_* timestamps
+	// (without time zone) are in
+	location *time.Location
+
+	tenantIDs       []string
+	withoutTenantID bool
+
+	definitionKeys         []string
+	excludedDefinitionKeys []string
+}
+
+// NewDBPoller connects to an engine database. The connection string is
+// fetched again for each new connection, so rotated credentials are used
+// without recreating the poller.
+func NewDBPoller(ctx context.Context, connString func() (string, error), batchSize int) (*DBPoller, error) {
+	initial, err := connString()
+	if err != nil {
+		return nil, fmt.Errorf("resolve engine database URL: %w", err)
+	}
+	poolCfg, err := pgxpool.ParseConfig(initial)
+	if err != nil {
+		return nil, fmt.Errorf("parse engine database URL: %w", err)
+	}
+	poolCfg.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+		current, err := connString()
+		if err != nil {
+			return fmt.Errorf("resolve engine database URL: %w", err)
+		}
+		latest, err := pgx.ParseConfig(current)
+		if err != nil {
+			return fmt.Errorf("parse engine database URL: %w", err)
+		}
+		cc.User = latest.User
+		cc.Password = latest.Password
+		return nil
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to engine database: %w", err)
+	}
+	return &DBPoller{pool: pool, batchSize: batchSize, location: time.UTC}, nil
+}
+
+// Ping checks connectivity to the engine database
+func (p *DBPoller) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+// Close closes the database connection pool
+func (p *DBPoller) Close() {
+	p.pool.Close()
+}
+
+// SetBatchSize changes how many process instances are read per poll
+func (p *DBPoller) SetBatchSize(n int) {
+	p.batchSize = n
+}
+
+// SetFetchBinaryVariables controls whether Bytes and File variable content is
+// included in events
+func (p *DBPoller) SetFetchBinaryVariables(enabled bool) {
+	p.fetchBinary = enabled
+}
+
+// SetTimeZone sets the time zone the engine writes history timestamps in,
+// that of the engine's JVM. The default is UTC.
+func (p *DBPoller) SetTimeZone(loc *time.Location) {
+	p.location = loc
+}
+
+// engineTime interprets a timestamp read from the history tables, whose wall
+// clock pgx returns as UTC, in the engine's time zone
+func (p *DBPoller) engineTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), p.location)
+}
+
+// engineTimePtr is engineTime for nullable timestamps
+func (p *DBPoller) engineTimePtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := p.engineTime(*t)
+	return &local
+}
+
+// SetTenantFilter restricts polling to the given tenants and/or to process
+// instances without a tenant
+func (p *DBPoller) SetTenantFilter(tenantIDs []string, withoutTenantID bool) {
+	p.tenantIDs = tenantIDs
+	p.withoutTenantID = withoutTenantID
+}
+
+// SetProcessDefinitionFilter restricts polling to process instances of the
+// given definition keys (all if empty), leaving out the excluded keys
+func (p *DBPoller) SetProcessDefinitionFilter(keys, excluded []string) {
+	p.definitionKeys = keys
+	p.excludedDefinitionKeys = excluded
+}
+
+// SetCheckpoint sets the polling checkpoint; nil polls from the start.
+// Polling resumes with the instances started at t, unless SetCheckpointID
+// is called too.
+func (p *DBPoller) SetCheckpoint(t *time.Time) {
+	p.lastStartTime = t
+	p.lastID = ""
+}
+
+// GetCheckpoint returns the current polling checkpoint
+func (p *DBPoller) GetCheckpoint() *time.Time {
+	return p.lastStartTime
+}
+
+// SetCheckpointID sets the id of the last instance read at the checkpoint's
+// start time, so polling resumes after it rather than reading the instances
+// that share its start time again
+func (p *DBPoller) SetCheckpointID(id string) {
+	p.lastID = id
+}
+
+// GetCheckpointID returns the id of the last instance read at the
+// checkpoint's start time
+func (p *DBPoller) GetCheckpointID() string {
+	return p.lastID
+}
+
+// Poll fetches new process instances and their history
+func (p *DBPoller) Poll(ctx context.Context) ([]ProcessEvent, error) {
+	processes, lastStart, err := p.queryProcessInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(processes) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(processes))
+	for i, proc := range processes {
+		ids[i] = proc.ID
+	}
+
+	activities, err := p.queryActivityInstances(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := p.queryVariableInstances(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	updateTimes, err := p.queryVariableUpdateTimes(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only move the cursor once the whole batch has been read
+	p.lastStartTime = &lastStart
+	p.lastID = processes[len(processes)-1].ID
+
+	events := make([]ProcessEvent, 0, len(processes))
+	for _, proc := range processes {
+		event := processInstanceEvent(proc)
+		event.Activities = activities[proc.ID]
+
+		// Always a map, even an empty one: the variables were read, and an
+		// empty set means they were all removed
+		vars := variables[proc.ID]
+		event.Variables = make(map[string]any, len(vars))
+		if len(vars) > 0 {
+			var latest time.Time
+			for _, v := range vars {
+				value, err := DecodeVariableValue(v.instance.Type, v.instance.Value, v.instance.ValueInfo, v.data, DateLayouts{})
+				if err != nil {
+					logging.FromContext(ctx).Warn("Failed to decode variable", "process_instance_id", proc.ID,
+						"variable", v.instance.Name, "type", v.instance.Type, logging.Error(err))
+					value = v.instance.Value
+				}
+				event.Variables[v.instance.Name] = value
+				if v.instance.CreateTime.After(latest) {
+					latest = v.instance.CreateTime.Time
+				}
+			}
+			if t, ok := updateTimes[proc.ID]; ok && t.After(latest) {
+				latest = t
+			}
+			event.VariablesTime = NewTimePtr(&latest)
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// queryProcessInstances returns the next batch of process instances after
+// the cursor, and the start time of the last one
+func (p *DBPoller) queryProcessInstances(ctx context.Context) ([]HistoricProcessInstance, time.Time, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if p.lastStartTime != nil {
+		// pgx sends the wall clock of the time's own location
+		start := p.lastStartTime.In(p.location)
+		where = append(where, fmt.Sprintf("(pi.START_TIME_, pi.ID_) > (%s, %s)", arg(start), arg(p.lastID)))
+	}
+	var tenant []string
+	if len(p.tenantIDs) > 0 {
+		tenant = append(tenant, "pi.TENANT_ID_ = ANY("+arg(p.tenantIDs)+")")
+	}
+	if p.withoutTenantID {
+		tenant = append(tenant, "pi.TENANT_ID_ IS NULL")
+	}
+	if len(tenant) > 0 {
+		where = append(where, "("+strings.Join(tenant, " OR ")+")")
+	}
+	if len(p.definitionKeys) > 0 {
+		where = append(where, "pi.PROC_DEF_KEY_ = ANY("+arg(p.definitionKeys)+")")
+	}
+	if len(p.excludedDefinitionKeys) > 0 {
+		where = append(where, "pi.PROC_DEF_KEY_ <> ALL("+arg(p.excludedDefinitionKeys)+")")
+	}
+
+	sql := `
+		SELECT pi.ID_, pi.BUSINESS_KEY_, pi.PROC_DEF_ID_, pi.PROC_DEF_KEY_, pd.NAME_, COALESCE(pd.VERSION_, 0),
+			pi.START_TIME_, pi.END_TIME_, pi.DURATION_, pi.START_USER_ID_, COALESCE(pi.START_ACT_ID_, ''),
+			pi.DELETE_REASON_, pi.SUPER_PROCESS_INSTANCE_ID_, pi.ROOT_PROC_INST_ID_, pi.TENANT_ID_, COALESCE(pi.STATE_, '')
+		FROM ACT_HI_PROCINST pi
+		LEFT JOIN ACT_RE_PROCDEF pd ON pd.ID_ = pi.PROC_DEF_ID_`
+	if len(where) > 0 {
+		sql += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	sql += "\n\t\tORDER BY pi.START_TIME_, pi.ID_\n\t\tLIMIT " + arg(p.batchSize)
+
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("query ACT_HI_PROCINST: %w", err)
+	}
+	defer rows.Close()
+
+	var result []HistoricProcessInstance
+	var lastStart time.Time
+	for rows.Next() {
+		var proc HistoricProcessInstance
+		var start time.Time
+		var end *time.Time
+		if err := rows.Scan(&proc.ID, &proc.BusinessKey, &proc.ProcessDefinitionID, &proc.ProcessDefinitionKey,
+			&proc.ProcessDefinitionName, &proc.ProcessDefinitionVersion, &start, &end, &proc.DurationInMillis,
+			&proc.StartUserID, &proc.StartActivityID, &proc.DeleteReason, &proc.SuperProcessInstanceID,
+			&proc.RootProcessInstanceID, &proc.TenantID, &proc.State); err != nil {
+			return nil, time.Time{}, fmt.Errorf("scan ACT_HI_PROCINST: %w", err)
+		}
+		start = p.engineTime(start)
+		proc.StartTime = NewTime(start)
+		proc.EndTime = NewTimePtr(p.engineTimePtr(end))
+		result = append(result, proc)
+		lastStart = start
+	}
+	return result, lastStart, rows.Err()
+}
+
+func (p *DBPoller) queryActivityInstances(ctx context.Context, processInstanceIDs []string) (map[string][]HistoricActivityInstance, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT ID_, PARENT_ACT_INST_ID_, ACT_ID_, ACT_NAME_, ACT_TYPE_, PROC_DEF_KEY_, PROC_DEF_ID_,
+			PROC_INST_ID_, EXECUTION_ID_, TASK_ID_, ASSIGNEE_, CALL_PROC_INST_ID_, CALL_CASE_INST_ID_,
+			START_TIME_, END_TIME_, DURATION_, COALESCE(ACT_INST_STATE_, 0), TENANT_ID_
+		FROM ACT_HI_ACTINST
+		WHERE PROC_INST_ID_ = ANY($1)
+		ORDER BY START_TIME_, SEQUENCE_COUNTER_`, processInstanceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("query ACT_HI_ACTINST: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string][]HistoricActivityInstance)
+	for rows.Next() {
+		var act HistoricActivityInstance
+		var start time.Time
+		var end *time.Time
+		var state int
+		if err := rows.Scan(&act.ID, &act.ParentActivityInstanceID, &act.ActivityID, &act.ActivityName,
+			&act.ActivityType, &act.ProcessDefinitionKey, &act.ProcessDefinitionID, &act.ProcessInstanceID,
+			&act.ExecutionID, &act.TaskID, &act.Assignee, &act.CalledProcessInstanceID,
+			&act.CalledCaseInstanceID, &start, &end, &act.DurationInMillis, &state, &act.TenantID); err != nil {
+			return nil, fmt.Errorf("scan ACT_HI_ACTINST: %w", err)
+		}
+		act.StartTime = NewTime(p.engineTime(start))
+		act.EndTime = NewTimePtr(p.engineTimePtr(end))
+		act.Canceled = state == activityStateCanceled
+		act.CompleteScope = state == activityStateScopeComplete
+		result[act.ProcessInstanceID] = append(result[act.ProcessInstanceID], act)
+	}
+	return result, rows.Err()
+}
+
+// dbVariable is a variable row with its byte array content, if any
+type dbVariable struct {
+	instance HistoricVariableInstance
+	data     []byte
+}
+
+// dbVariableTypes maps ACT_HI_VARINST.VAR_TYPE_ serializer names to the
+// value types reported by the REST API
+var dbVariableTypes = map[string]string{
+	"null":    TypeNull,
+	"string":  TypeString,
+	"boolean": TypeBoolean,
+	"short":   TypeShort,
+	"integer": TypeInteger,
+	"long":    TypeLong,
+	"double":  TypeDouble,
+	"date":    TypeDate,
+	"json":    TypeJSON,
+	"xml":     TypeXML,
+	"object":  TypeObject,
+	"bytes":   TypeBytes,
+	"file":    TypeFile,
+}
+
+func (p *DBPoller) queryVariableInstances(ctx context.Context, processInstanceIDs []string) (map[string][]dbVariable, error) {
+	join := "b.ID_ = v.BYTEARRAY_ID_"
+	if !p.fetchBinary {
+		// JSON and XML values are stored as byte arrays too, so only skip
+		// reading the binary ones
+		join += ` AND (v.VAR_TYPE_ IN ('json', 'xml')
+			OR v.TEXT2_ LIKE 'application/json%' OR v.TEXT2_ LIKE 'application/xml%')`
+	}
+	rows, err := p.pool.Query(ctx, `
+		SELECT v.ID_, v.NAME_, v.VAR_TYPE_, v.PROC_DEF_ID_, v.PROC_INST_ID_, v.EXECUTION_ID_, v.ACT_INST_ID_,
+			v.TASK_ID_, v.CREATE_TIME_, COALESCE(v.STATE_, ''), v.TENANT_ID_,
+			v.TEXT_, v.TEXT2_, v.LONG_, v.DOUBLE_, v.BYTEARRAY_ID_ IS NOT NULL, b.BYTES_
+		FROM ACT_HI_VARINST v
+		LEFT JOIN ACT_GE_BYTEARRAY b ON `+join+`
+		WHERE v.PROC_INST_ID_ = ANY($1)
+			AND (v.STATE_ IS NULL OR v.STATE_ <> 'DELETED')`, processInstanceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("query ACT_HI_VARINST: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string][]dbVariable)
+	for rows.Next() {
+		var v HistoricVariableInstance
+		var serializer string
+		var created *time.Time
+		var text, text2 *string
+		var long *int64
+		var double *float64
+		var hasData bool
+		var data []byte
+		if err := rows.Scan(&v.ID, &v.Name, &serializer, &v.ProcessDefinitionID, &v.ProcessInstanceID,
+			&v.ExecutionID, &v.ActivityInstanceID, &v.TaskID, &created, &v.State, &v.TenantID,
+			&text, &text2, &long, &double, &hasData, &data); err != nil {
+			return nil, fmt.Errorf("scan ACT_HI_VARINST: %w", err)
+		}
+		if created != nil {
+			v.CreateTime = NewTime(p.engineTime(*created))
+		}
+
+		v.Type = dbVariableTypes[serializer]
+		if v.Type == "" {
+			// Java serialized and other custom serializers are stored as objects
+			v.Type = TypeObject
+		}
+
+		switch v.Type {
+		case TypeString:
+			v.Value = deref(text)
+		case TypeBoolean:
+			if long != nil {
+				v.Value = *long != 0
+			}
+		case TypeShort, TypeInteger, TypeLong:
+			if long != nil {
+				v.Value = *long
+			}
+		case TypeDouble:
+			if double != nil {
+				v.Value = *double
+			}
+		case TypeDate:
+			if long != nil {
+				v.Value = NewTime(time.UnixMilli(*long)).String()
+			}
+		case TypeJSON, TypeXML:
+			if data != nil {
+				v.Value = string(data)
+			} else {
+				v.Value = deref(text)
+			}
+			data = nil
+		case TypeObject:
+			v.ValueInfo = map[string]any{"objectTypeName": deref(text), "serializationDataFormat": deref(text2)}
+			if strings.HasPrefix(deref(text2), "application/json") || strings.HasPrefix(deref(text2), "application/xml") {
+				v.Value = string(data)
+			} else if hasData {
+				v.Type = TypeBytes
+			}
+		case TypeFile:
+			// TEXT2_ holds "mimeType#encoding"
+			mimeType, encoding, _ := strings.Cut(deref(text2), "#")
+			v.ValueInfo = map[string]any{"filename": deref(text), "mimeType": mimeType, "encoding": encoding}
+		}
+		if !p.fetchBinary && IsBinaryType(v.Type) {
+			data = nil
+		}
+
+		result[v.ProcessInstanceID] = append(result[v.ProcessInstanceID], dbVariable{instance: v, data: data})
+	}
+	return result, rows.Err()
+}
+
+// queryVariableUpdateTimes returns the time of the latest variable update of
+// each process instance from ACT_HI_DETAIL, which unlike ACT_HI_VARINST
+// records when existing variables change
+func (p *DBPoller) queryVariableUpdateTimes(ctx context.Context, processInstanceIDs []string) (map[string]time.Time, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT PROC_INST_ID_, MAX(TIME_)
+		FROM ACT_HI_DETAIL
+		WHERE PROC_INST_ID_ = ANY($1) AND TYPE_ = 'VariableUpdate'
+		GROUP BY PROC_INST_ID_`, processInstanceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("query ACT_HI_DETAIL: %w", err)
+	}
+
+	result := make(map[string]time.Time)
+	var id string
+	var t time.Time
+	_, err = pgx.ForEachRow(rows, []any{&id, &t}, func() error {
+		result[id] = p.engineTime(t)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan ACT_HI_DETAIL: %w", err)
+	}
+	return result, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,157 @@
+package fluxnova
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// extraHistoryRows add to demo/fixtures/history-tables.sql an instance
+// started at the same time as pi-1, a later one, and a deleted variable
+const extraHistoryRows = `
+INSERT INTO ACT_HI_PROCINST VALUES
+    ('pi-2', 'pi-2', 'TICKET-1002', 'customer-service-ticket', 'customer-service-ticket:1:def-1',
+     '2024-06-15 10:00:00.120', NULL, NULL, NULL, 'StartEvent_Ticket',
+     NULL, NULL, 'pi-2', NULL, 'ACTIVE'),
+    ('pi-3', 'pi-3', 'TICKET-1003', 'customer-service-ticket', 'customer-service-ticket:1:def-1',
+     '2024-06-15 11:00:00', NULL, NULL, NULL, 'StartEvent_Ticket',
+     NULL, NULL, 'pi-3', NULL, 'ACTIVE');
+
+INSERT INTO ACT_HI_VARINST VALUES
+    ('var-4', 'customer-service-ticket', 'customer-service-ticket:1:def-1', 'pi-1', 'pi-1', 'pi-1', NULL,
+     'draft', 'string', '2024-06-15 10:00:00.200', NULL, NULL, NULL, 'obsolete', NULL, NULL, 'DELETED');
+`
+
+// TestDBPoller polls the history fixture in a schema of its own in the
+// Postgres database named by CDC_TEST_DATABASE_URL, and is skipped without one
+func TestDBPoller(t *testing.T) {
+	url := os.Getenv("CDC_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("CDC_TEST_DATABASE_URL is not set")
+	}
+	url = loadHistoryFixture(t, url)
+	started := time.Date(2024, 6, 15, 10, 0, 0, 120_000_000, time.UTC)
+
+	tests := []struct {
+		name string
+		// checkpoint and checkpointID are where polling resumes, if set
+		checkpoint   *time.Time
+		checkpointID string
+		want         []string
+		wantID       string
+	}{
+		{
+			name:   "first poll",
+			want:   []string{"pi-1", "pi-2"},
+			wantID: "pi-2",
+		},
+		{
+			name:         "resumes after the instance with the same start time",
+			checkpoint:   &started,
+			checkpointID: "pi-1",
+			want:         []string{"pi-2", "pi-3"},
+			wantID:       "pi-3",
+		},
+		{
+			name:       "checkpoint without an id rereads the start time",
+			checkpoint: &started,
+			want:       []string{"pi-1", "pi-2"},
+			wantID:     "pi-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poller, err := NewDBPoller(context.Background(), func() (string, error) { return url, nil }, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer poller.Close()
+			if tt.checkpoint != nil {
+				poller.SetCheckpoint(tt.checkpoint)
+				poller.SetCheckpointID(tt.checkpointID)
+			}
+
+			events, err := poller.Poll(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, event := range events {
+				got = append(got, event.ProcessInstanceID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Poll() read %v, want %v", got, tt.want)
+			}
+			if id := poller.GetCheckpointID(); id != tt.wantID {
+				t.Errorf("GetCheckpointID() = %q, want %q", id, tt.wantID)
+			}
+		})
+	}
+
+	t.Run("history of an instance", func(t *testing.T) {
+		poller, err := NewDBPoller(context.Background(), func() (string, error) { return url, nil }, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer poller.Close()
+
+		events, err := poller.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("Poll() read %d events, want 1", len(events))
+		}
+		event := events[0]
+		if event.ProcessInstanceID != "pi-1" || event.State != "COMPLETED" || event.EndTime == nil {
+			t.Errorf("Poll() = %+v, want completed instance pi-1", event)
+		}
+		if len(event.Activities) != 3 {
+			t.Errorf("Poll() read %d activities, want 3", len(event.Activities))
+		}
+		// The deleted variable is left out
+		wantVariables := map[string]any{"customerId": "CUST-042", "priority": int64(2), "sentimentScore": -0.62}
+		if !reflect.DeepEqual(event.Variables, wantVariables) {
+			t.Errorf("Poll() variables = %#v, want %#v", event.Variables, wantVariables)
+		}
+	})
+}
+
+// loadHistoryFixture creates a schema for the test holding the history
+// fixture, and returns url with that schema as the search path
+func loadHistoryFixture(t *testing.T, url string) string {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("cdc_test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		conn.Close(ctx)
+	})
+
+	fixture, err := os.ReadFile("../../demo/fixtures/history-tables.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{"CREATE SCHEMA " + schema, "SET search_path TO " + schema, string(fixture), extraHistoryRows} {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + "search_path=" + schema
+}
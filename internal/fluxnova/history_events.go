@@ -0,0 +1,177 @@
+package fluxnova
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProcessEvent.EventType values. Polled events are always ProcessInstance
+// events carrying the full history of an instance; pushed history events
+// carry a single entity.
+const (
+	EventTypeProcessInstance  = "ProcessInstance"
+	EventTypeActivityInstance = "ActivityInstance"
+	EventTypeVariableUpdate   = "VariableUpdate"
+)
+
+// HistoryEvent is a history event pushed by an engine-side history event
+// handler. The handler serializes the engine's history entity in the same
+// shape as the REST history API, under the key matching EntityType.
+type HistoryEvent struct {
+	// EntityType is "process-instance", "activity-instance" or "variable"
+	EntityType string `json:"entityType"`
+	// EventType is the engine's history event type, e.g. "start", "end",
+	// "create", "update"
+	EventType string `json:"eventType"`
+	// Time is when the event happened in the engine
+	Time Time `json:"time"`
+
+	ProcessInstance  *HistoricProcessInstance  `json:"processInstance,omitempty"`
+	ActivityInstance *HistoricActivityInstance `json:"activityInstance,omitempty"`
+	Variable         *HistoricVariableInstance `json:"variable,omitempty"`
+}
+
+// DecodeHistoryEvents reads history events from r, which may hold a single
+// JSON object, a JSON array, or newline-delimited objects, parsing their
+// timestamps with the engine's date layouts
+func DecodeHistoryEvents(r io.Reader, layouts DateLayouts) ([]HistoryEvent, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first == '[' {
+		var events []HistoryEvent
+		if err := json.NewDecoder(br).Decode(&events); err != nil {
+			return nil, fmt.Errorf("decode history events: %w", err)
+		}
+		if err := layouts.Resolve(&events); err != nil {
+			return nil, fmt.Errorf("decode history events: %w", err)
+		}
+		return events, nil
+	}
+
+	var events []HistoryEvent
+	dec := json.NewDecoder(br)
+	for {
+		var event HistoryEvent
+		err := dec.Decode(&event)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err == nil {
+			err = layouts.Resolve(&event)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode history event %d: %w", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+}
+
+// definitionKeyFromID extracts the key from a process definition id of the
+// form key:version:uuid
+func definitionKeyFromID(id string) string {
+	key, _, _ := strings.Cut(id, ":")
+	return key
+}
+
+// DefinitionVersionFromID extracts the version from a process definition id
+// of the form key:version:uuid, or returns 0
+func DefinitionVersionFromID(id string) int {
+	parts := strings.Split(id, ":")
+	if len(parts) < 3 {
+		return 0
+	}
+	version, _ := strconv.Atoi(parts[len(parts)-2])
+	return version
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		br.ReadByte()
+	}
+}
+
+// ToProcessEvent normalizes a pushed history event into the ProcessEvent
+// form produced by the poller. Activity and variable events only carry the
+// changed entity and the owning process instance id. Date variables are
+// parsed with layouts.
+func (e HistoryEvent) ToProcessEvent(layouts DateLayouts) (ProcessEvent, error) {
+	event := ProcessEvent{Timestamp: time.Now()}
+
+	switch e.EntityType {
+	case "process-instance":
+		proc := e.ProcessInstance
+		if proc == nil {
+			return event, fmt.Errorf("%s event has no processInstance", e.EntityType)
+		}
+		event = processInstanceEvent(*proc)
+
+	case "activity-instance":
+		act := e.ActivityInstance
+		if act == nil {
+			return event, fmt.Errorf("%s event has no activityInstance", e.EntityType)
+		}
+		event.EventType = EventTypeActivityInstance
+		event.ProcessInstanceID = act.ProcessInstanceID
+		event.ProcessDefinition = act.ProcessDefinitionKey
+		event.DefinitionVersion = DefinitionVersionFromID(act.ProcessDefinitionID)
+		event.TenantID = act.TenantID
+		event.Activities = []HistoricActivityInstance{*act}
+
+	case "variable":
+		v := e.Variable
+		if v == nil {
+			return event, fmt.Errorf("%s event has no variable", e.EntityType)
+		}
+		event.EventType = EventTypeVariableUpdate
+		event.ProcessInstanceID = v.ProcessInstanceID
+		event.ProcessDefinition = definitionKeyFromID(v.ProcessDefinitionID)
+		event.DefinitionVersion = DefinitionVersionFromID(v.ProcessDefinitionID)
+		event.TenantID = v.TenantID
+
+		if e.EventType == "delete" {
+			event.DeletedVariables = []string{v.Name}
+		} else {
+			value, err := DecodeVariableValue(v.Type, v.Value, v.ValueInfo, nil, layouts)
+			if err != nil {
+				return event, fmt.Errorf("variable %s: %w", v.Name, err)
+			}
+			event.Variables = map[string]any{v.Name: value}
+		}
+
+		changed := e.Time
+		if changed.IsZero() {
+			changed = v.CreateTime
+		}
+		if !changed.IsZero() {
+			event.VariablesTime = &changed
+		}
+
+	default:
+		return event, fmt.Errorf("unknown history entity type %q", e.EntityType)
+	}
+
+	if event.ProcessInstanceID == "" {
+		return event, fmt.Errorf("%s event has no process instance id", e.EntityType)
+	}
+	return event, nil
+}
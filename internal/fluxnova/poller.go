@@ -2,23 +2,31 @@ package fluxnova
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
 )
 
 // ProcessEvent represents a CDC event for a process instance
 type ProcessEvent struct {
-	EventType         string                   `json:"event_type"`
-	ProcessInstanceID string                   `json:"process_instance_id"`
-	ProcessDefinition string                   `json:"process_definition_key"`
-	BusinessKey       *string                  `json:"business_key,omitempty"`
-	State             string                   `json:"state"`
-	StartTime         string                   `json:"start_time"`
-	EndTime           *string                  `json:"end_time,omitempty"`
-	DurationMillis    *int64                   `json:"duration_millis,omitempty"`
+	EventType         string                     `json:"event_type"`
+	ProcessInstanceID string                     `json:"process_instance_id"`
+	ProcessDefinition string                     `json:"process_definition_key"`
+	DefinitionVersion int                        `json:"process_definition_version,omitempty"`
+	BusinessKey       *string                    `json:"business_key,omitempty"`
+	SuperProcessID    *string                    `json:"super_process_instance_id,omitempty"`
+	RootProcessID     *string                    `json:"root_process_instance_id,omitempty"`
+	TenantID          *string                    `json:"tenant_id,omitempty"`
+	State             string                     `json:"state"`
+	StartTime         Time                       `json:"start_time"`
+	EndTime           *Time                      `json:"end_time,omitempty"`
+	DurationMillis    *int64                     `json:"duration_millis,omitempty"`
 	Activities        []HistoricActivityInstance `json:"activities,omitempty"`
-	Variables         map[string]any           `json:"variables,omitempty"`
-	Timestamp         time.Time                `json:"timestamp"`
+	Variables         map[string]any             `json:"variables,omitempty"`
+	VariablesTime     *Time                      `json:"variables_time,omitempty"`
+	DeletedVariables  []string                   `json:"deleted_variables,omitempty"`
+	Timestamp         time.Time                  `json:"timestamp"`
 }
 
 // Poller polls Fluxnova for process history
@@ -26,6 +34,13 @@ type Poller struct {
 	client       *Client
 	batchSize    int
 	lastPollTime *time.Time
+	fetchBinary  bool
+
+	tenantIDs       []string
+	withoutTenantID bool
+
+	definitionKeys         []string
+	excludedDefinitionKeys []string
 }
 
 // NewPoller creates a new Fluxnova poller
@@ -38,7 +53,15 @@ func NewPoller(client *Client, batchSize int) *Poller {
 
 // Poll fetches new process instances and their history
 func (p *Poller) Poll(ctx context.Context) ([]ProcessEvent, error) {
-	processes, err := p.client.GetHistoricProcessInstances(p.lastPollTime, p.batchSize)
+	processes, err := p.client.GetHistoricProcessInstances(ProcessInstanceQuery{
+		StartedAfter:    p.lastPollTime,
+		TenantIDs:       p.tenantIDs,
+		WithoutTenantID: p.withoutTenantID,
+		MaxResults:      p.batchSize,
+
+		ProcessDefinitionKeys:         p.definitionKeys,
+		ExcludedProcessDefinitionKeys: p.excludedDefinitionKeys,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -47,24 +70,46 @@ func (p *Poller) Poll(ctx context.Context) ([]ProcessEvent, error) {
 		return nil, nil
 	}
 
-	var events []ProcessEvent
+	events := p.buildEvents(ctx, processes)
+
 	for _, proc := range processes {
-		event := ProcessEvent{
-			EventType:         "ProcessInstance",
-			ProcessInstanceID: proc.ID,
-			ProcessDefinition: proc.ProcessDefinitionKey,
-			BusinessKey:       proc.BusinessKey,
-			State:             proc.State,
-			StartTime:         proc.StartTime,
-			EndTime:           proc.EndTime,
-			DurationMillis:    proc.DurationInMillis,
-			Timestamp:         time.Now(),
+		// Update last poll time
+		if startTime := proc.StartTime.Time; p.lastPollTime == nil || startTime.After(*p.lastPollTime) {
+			p.lastPollTime = &startTime
 		}
+	}
+
+	return events, nil
+}
+
+// PollQuery fetches one page of process instances matching q, with their
+// history, without moving the poller's checkpoint. The poller's tenant and
+// process definition filters and batch size are applied to the query.
+func (p *Poller) PollQuery(ctx context.Context, q ProcessInstanceQuery) ([]ProcessEvent, error) {
+	q.TenantIDs = p.tenantIDs
+	q.WithoutTenantID = p.withoutTenantID
+	q.ProcessDefinitionKeys = p.definitionKeys
+	q.ExcludedProcessDefinitionKeys = p.excludedDefinitionKeys
+	q.MaxResults = p.batchSize
+
+	processes, err := p.client.GetHistoricProcessInstances(q)
+	if err != nil {
+		return nil, err
+	}
+	return p.buildEvents(ctx, processes), nil
+}
+
+// buildEvents fetches the activities and variables of each process instance
+func (p *Poller) buildEvents(ctx context.Context, processes []HistoricProcessInstance) []ProcessEvent {
+	var events []ProcessEvent
+	for _, proc := range processes {
+		event := processInstanceEvent(proc)
+		logger := logging.FromContext(ctx).With("process_instance_id", proc.ID)
 
 		// Fetch activities for this process
 		activities, err := p.client.GetHistoricActivityInstances(proc.ID)
 		if err != nil {
-			log.Printf("Warning: failed to fetch activities for %s: %v", proc.ID, err)
+			logger.Warn("Failed to fetch activities", logging.Error(err))
 		} else {
 			event.Activities = activities
 		}
@@ -72,31 +117,95 @@ func (p *Poller) Poll(ctx context.Context) ([]ProcessEvent, error) {
 		// Fetch variables for this process
 		variables, err := p.client.GetHistoricVariableInstances(proc.ID)
 		if err != nil {
-			log.Printf("Warning: failed to fetch variables for %s: %v", proc.ID, err)
+			logger.Warn("Failed to fetch variables", logging.Error(err))
 		} else {
 			event.Variables = make(map[string]any)
+			var latest time.Time
 			for _, v := range variables {
-				event.Variables[v.Name] = v.Value
+				event.Variables[v.Name] = p.decodeVariable(logger, v)
+				if v.CreateTime.After(latest) {
+					latest = v.CreateTime.Time
+				}
+			}
+			if !latest.IsZero() {
+				event.VariablesTime = NewTimePtr(&latest)
 			}
 		}
 
 		events = append(events, event)
+	}
+	return events
+}
 
-		// Update last poll time
-		startTime, err := time.Parse("2006-01-02T15:04:05.000-0700", proc.StartTime)
-		if err == nil {
-			if p.lastPollTime == nil || startTime.After(*p.lastPollTime) {
-				p.lastPollTime = &startTime
-			}
+// processInstanceEvent creates the event for a process instance, without
+// its activities and variables
+func processInstanceEvent(proc HistoricProcessInstance) ProcessEvent {
+	return ProcessEvent{
+		EventType:         EventTypeProcessInstance,
+		ProcessInstanceID: proc.ID,
+		ProcessDefinition: proc.ProcessDefinitionKey,
+		DefinitionVersion: proc.ProcessDefinitionVersion,
+		BusinessKey:       proc.BusinessKey,
+		SuperProcessID:    proc.SuperProcessInstanceID,
+		RootProcessID:     proc.RootProcessInstanceID,
+		TenantID:          proc.TenantID,
+		State:             proc.State,
+		StartTime:         proc.StartTime,
+		EndTime:           proc.EndTime,
+		DurationMillis:    proc.DurationInMillis,
+		Timestamp:         time.Now(),
+	}
+}
+
+// decodeVariable returns the structured value of a variable, falling back to
+// the raw value if it cannot be decoded
+func (p *Poller) decodeVariable(logger *slog.Logger, v HistoricVariableInstance) any {
+	var data []byte
+	if p.fetchBinary && IsBinaryType(v.Type) {
+		d, err := p.client.GetHistoricVariableInstanceData(v.ID)
+		if err != nil {
+			logger.Warn("Failed to fetch variable data", "variable", v.Name, logging.Error(err))
+		} else {
+			data = d
 		}
 	}
 
-	return events, nil
+	value, err := DecodeVariableValue(v.Type, v.Value, v.ValueInfo, data, p.client.layouts)
+	if err != nil {
+		logger.Warn("Failed to decode variable", "variable", v.Name, "type", v.Type, logging.Error(err))
+		return v.Value
+	}
+	return value
+}
+
+// SetBatchSize changes how many process instances are read per poll
+func (p *Poller) SetBatchSize(n int) {
+	p.batchSize = n
+}
+
+// SetFetchBinaryVariables controls whether Bytes and File variable content is
+// fetched from the engine
+func (p *Poller) SetFetchBinaryVariables(enabled bool) {
+	p.fetchBinary = enabled
+}
+
+// SetTenantFilter restricts polling to the given tenants and/or to process
+// instances without a tenant
+func (p *Poller) SetTenantFilter(tenantIDs []string, withoutTenantID bool) {
+	p.tenantIDs = tenantIDs
+	p.withoutTenantID = withoutTenantID
+}
+
+// SetProcessDefinitionFilter restricts polling to process instances of the
+// given definition keys (all if empty), leaving out the excluded keys
+func (p *Poller) SetProcessDefinitionFilter(keys, excluded []string) {
+	p.definitionKeys = keys
+	p.excludedDefinitionKeys = excluded
 }
 
-// SetCheckpoint sets the polling checkpoint
-func (p *Poller) SetCheckpoint(t time.Time) {
-	p.lastPollTime = &t
+// SetCheckpoint sets the polling checkpoint; nil polls from the start
+func (p *Poller) SetCheckpoint(t *time.Time) {
+	p.lastPollTime = t
 }
 
 // GetCheckpoint returns the current polling checkpoint
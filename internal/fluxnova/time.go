@@ -0,0 +1,302 @@
+package fluxnova
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDateLayout is the engine's default dateFormat
+// (yyyy-MM-dd'T'HH:mm:ss.SSSZ) as a Go layout
+const DefaultDateLayout = "2006-01-02T15:04:05.000-0700"
+
+// defaultDateLayouts are the formats history timestamps are parsed with
+// unless an engine has a custom dateFormat. Fractional seconds are optional
+// in both, and "Z0700" accepts both "Z" and a numeric offset.
+var defaultDateLayouts = []string{
+	"2006-01-02T15:04:05Z0700",
+	time.RFC3339Nano,
+}
+
+// outputLayout is RFC3339 in UTC with a fixed nanosecond fraction. Unlike
+// time.RFC3339Nano, which drops trailing zeros, fixed-width times sort
+// correctly as strings, and the microseconds of database timestamps are kept.
+const outputLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Time is a timestamp in the engine's history. It accepts any of the engine's
+// date formats when decoded and is always encoded as UTC RFC3339, so values
+// from engines in different time zones order correctly.
+type Time struct {
+	time.Time
+	// raw is a decoded value in a custom layout, left for
+	// DateLayouts.Resolve to parse with its engine's layout
+	raw string
+}
+
+// customLayouts are the custom layouts of every engine. Decoding doesn't
+// know which engine a time came from, so it only rejects values in none of
+// them, leaving the others for DateLayouts.Resolve.
+var customLayouts struct {
+	sync.RWMutex
+	layouts []string
+}
+
+// matchesCustomLayout reports whether s parses with any engine's custom
+// layout
+func matchesCustomLayout(s string) bool {
+	customLayouts.RLock()
+	defer customLayouts.RUnlock()
+	for _, layout := range customLayouts.layouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// DateLayouts are the formats one engine's history timestamps are parsed
+// with: the default layouts, preceded by the engine's custom dateFormat if it
+// has one. The zero value holds only the defaults.
+type DateLayouts struct {
+	custom string
+}
+
+// NewDateLayouts returns the layouts of an engine with the given dateFormat,
+// a Java SimpleDateFormat pattern, or the defaults if it is empty
+func NewDateLayouts(dateFormat string) (DateLayouts, error) {
+	if dateFormat == "" {
+		return DateLayouts{}, nil
+	}
+	layout, err := convertDateFormat(dateFormat)
+	if err != nil {
+		return DateLayouts{}, err
+	}
+
+	customLayouts.Lock()
+	if !slices.Contains(customLayouts.layouts, layout) {
+		customLayouts.layouts = append(customLayouts.layouts, layout)
+	}
+	customLayouts.Unlock()
+	return DateLayouts{custom: layout}, nil
+}
+
+// QueryLayout returns the layout times are sent to the engine in
+func (l DateLayouts) QueryLayout() string {
+	if l.custom != "" {
+		return l.custom
+	}
+	return DefaultDateLayout
+}
+
+// Parse parses a timestamp in any of the engine's date formats
+func (l DateLayouts) Parse(s string) (Time, error) {
+	if l.custom != "" {
+		if t, err := time.Parse(l.custom, s); err == nil {
+			return Time{Time: t}, nil
+		}
+	}
+	return ParseTime(s)
+}
+
+// timeType is the reflect type of Time
+var timeType = reflect.TypeOf(Time{})
+
+// Resolve parses the timestamps in v, a pointer to decoded history, that
+// were left unparsed when decoding because they are in the engine's custom
+// format. It fails if any of them is in none of the engine's formats.
+func (l DateLayouts) Resolve(v any) error {
+	return l.resolve(reflect.ValueOf(v))
+}
+
+func (l DateLayouts) resolve(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return l.resolve(v.Elem())
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := l.resolve(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			if !v.CanAddr() {
+				return nil
+			}
+			t := v.Addr().Interface().(*Time)
+			if t.raw == "" {
+				return nil
+			}
+			parsed, err := l.Parse(t.raw)
+			if err != nil {
+				return err
+			}
+			*t = parsed
+			return nil
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				if err := l.resolve(v.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// NewTime wraps t as a history timestamp
+func NewTime(t time.Time) Time {
+	return Time{Time: t}
+}
+
+// NewTimePtr wraps t as a history timestamp, returning nil if t is nil
+func NewTimePtr(t *time.Time) *Time {
+	if t == nil {
+		return nil
+	}
+	ht := NewTime(*t)
+	return &ht
+}
+
+// ParseTime parses a timestamp in one of the default date formats
+func ParseTime(s string) (Time, error) {
+	for _, layout := range defaultDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return Time{Time: t}, nil
+		}
+	}
+	return Time{}, fmt.Errorf("unparseable time %q", s)
+}
+
+// String returns the time as UTC RFC3339 with nanoseconds
+func (t Time) String() string {
+	return t.UTC().Format(outputLayout)
+}
+
+// MarshalJSON encodes the time as a UTC RFC3339 string, or null if it is zero
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON decodes a timestamp in one of the default date formats.
+// Values in an engine's custom format are kept for DateLayouts.Resolve to
+// parse with that engine's format, and values in no known format are
+// rejected.
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = Time{}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("time must be a string: %w", err)
+	}
+	if s == "" {
+		*t = Time{}
+		return nil
+	}
+
+	parsed, err := ParseTime(s)
+	if err != nil {
+		if !matchesCustomLayout(s) {
+			return err
+		}
+		*t = Time{raw: s}
+		return nil
+	}
+	*t = parsed
+	return nil
+}
+
+// javaDateFields maps SimpleDateFormat fields (runs of one pattern letter) to
+// Go layout elements
+var javaDateFields = []struct {
+	java, goLayout string
+}{
+	{"yyyy", "2006"},
+	{"yy", "06"},
+	{"MM", "01"},
+	{"dd", "02"},
+	{"HH", "15"},
+	{"hh", "03"},
+	{"mm", "04"},
+	{"ss", "05"},
+	{"SSS", "000"},
+	{"XXX", "Z07:00"},
+	{"XX", "Z0700"},
+	{"X", "Z07"},
+	{"Z", "-0700"},
+	{"a", "PM"},
+}
+
+// convertDateFormat converts a Java SimpleDateFormat pattern such as
+// yyyy-MM-dd'T'HH:mm:ss.SSSZ to a Go time layout
+func convertDateFormat(pattern string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+
+		// '' is a quote, inside quoted text or out
+		if strings.HasPrefix(pattern[i:], "''") {
+			b.WriteByte('\'')
+			i += 2
+			continue
+		}
+
+		// Quoted literal text
+		if c == '\'' {
+			i++
+			for {
+				end := strings.IndexByte(pattern[i:], '\'')
+				if end < 0 {
+					return "", fmt.Errorf("date format %q: unterminated quote", pattern)
+				}
+				b.WriteString(pattern[i : i+end])
+				i += end + 1
+				if !strings.HasPrefix(pattern[i:], "'") {
+					break
+				}
+				b.WriteByte('\'')
+				i++
+			}
+			continue
+		}
+
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			n := 1
+			for i+n < len(pattern) && pattern[i+n] == c {
+				n++
+			}
+			field := pattern[i : i+n]
+			converted := ""
+			for _, f := range javaDateFields {
+				if f.java == field {
+					converted = f.goLayout
+					break
+				}
+			}
+			if converted == "" {
+				return "", fmt.Errorf("date format %q: unsupported field %q", pattern, field)
+			}
+			b.WriteString(converted)
+			i += n
+			continue
+		}
+
+		b.WriteByte(c)
+		i++
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,133 @@
+package fluxnova
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConvertDateFormat(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{pattern: "yyyy-MM-dd'T'HH:mm:ss.SSSZ", want: DefaultDateLayout},
+		{pattern: "dd.MM.yyyy HH:mm:ss", want: "02.01.2006 15:04:05"},
+		{pattern: "yyyy-MM-dd'T'HH:mm:ssXXX", want: "2006-01-02T15:04:05Z07:00"},
+		{pattern: "yyyy-MM-dd'T'HH:mm:ssXX", want: "2006-01-02T15:04:05Z0700"},
+		{pattern: "yy/MM/dd hh:mm a", want: "06/01/02 03:04 PM"},
+		{pattern: "HH 'o''clock'", want: "15 o'clock"},
+		{pattern: "''yy", want: "'06"},
+		{pattern: "yyyy-MM-dd'T", wantErr: true},
+		{pattern: "EEE, dd MMM yyyy", wantErr: true},
+		{pattern: "yyyyy", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := convertDateFormat(tt.pattern)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("convertDateFormat() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("convertDateFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "2024-06-15T10:00:01.310+0000", want: "2024-06-15T10:00:01.310000000Z"},
+		{value: "2024-06-15T12:00:01.310+0200", want: "2024-06-15T10:00:01.310000000Z"},
+		{value: "2024-06-15T10:00:01+0000", want: "2024-06-15T10:00:01.000000000Z"},
+		{value: "2024-06-15T10:00:01.31Z", want: "2024-06-15T10:00:01.310000000Z"},
+		{value: "2024-06-15T10:00:01.123456Z", want: "2024-06-15T10:00:01.123456000Z"},
+		{value: "2024-06-15T05:30:01.123456789-04:30", want: "2024-06-15T10:00:01.123456789Z"},
+		{value: "2024-06-15T12:00:01+02:00", want: "2024-06-15T10:00:01.000000000Z"},
+		{value: "2024-06-15", wantErr: true},
+		{value: "2024-06-15 10:00:01", wantErr: true},
+		{value: "yesterday", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTime(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseTime() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseTime() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeUnmarshalJSON(t *testing.T) {
+	layouts, err := NewDateLayouts("dd/MM/yyyy HH:mm:ss")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		json string
+		// want is the time once resolved, "" for the zero time
+		want    string
+		wantErr bool
+	}{
+		{name: "null", json: `null`},
+		{name: "empty", json: `""`},
+		{name: "default format", json: `"2024-06-15T12:00:01.310+0200"`, want: "2024-06-15T10:00:01.310000000Z"},
+		{name: "custom format", json: `"15/06/2024 10:00:01"`, want: "2024-06-15T10:00:01.000000000Z"},
+		{name: "unparseable", json: `"15 June 2024"`, wantErr: true},
+		{name: "number", json: `1718445601310`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct {
+				Time Time `json:"time"`
+			}
+			err := json.Unmarshal([]byte(`{"time": `+tt.json+`}`), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal() = %v, want an error", got.Time)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := layouts.Resolve(&got); err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if !got.Time.IsZero() {
+					t.Errorf("Unmarshal() = %v, want the zero time", got.Time)
+				}
+				return
+			}
+			if got.Time.String() != tt.want {
+				t.Errorf("Unmarshal() = %s, want %s", got.Time, tt.want)
+			}
+		})
+	}
+}
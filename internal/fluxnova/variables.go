@@ -0,0 +1,94 @@
+package fluxnova
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Camunda variable value types as reported in the history API
+const (
+	TypeNull    = "Null"
+	TypeString  = "String"
+	TypeBoolean = "Boolean"
+	TypeShort   = "Short"
+	TypeInteger = "Integer"
+	TypeLong    = "Long"
+	TypeDouble  = "Double"
+	TypeDate    = "Date"
+	TypeJSON    = "Json"
+	TypeXML     = "Xml"
+	TypeObject  = "Object"
+	TypeBytes   = "Bytes"
+	TypeFile    = "File"
+)
+
+// IsBinaryType reports whether values of the given type are only available
+// through the variable instance /data endpoint
+func IsBinaryType(typ string) bool {
+	return typ == TypeBytes || typ == TypeFile
+}
+
+// DecodeVariableValue converts a raw history variable value into a structured
+// value according to its Camunda type. Json variables and Object variables
+// serialized as JSON become nested documents, dates in one of layouts are
+// normalized to UTC RFC3339, and binary data (if supplied) is base64-encoded.
+func DecodeVariableValue(typ string, value any, valueInfo map[string]any, data []byte, layouts DateLayouts) (any, error) {
+	switch typ {
+	case TypeJSON:
+		return decodeJSONString(value)
+
+	case TypeObject:
+		format, _ := valueInfo["serializationDataFormat"].(string)
+		if strings.HasPrefix(format, "application/json") {
+			return decodeJSONString(value)
+		}
+		return value, nil
+
+	case TypeDate:
+		s, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		t, err := layouts.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("Date value: %w", err)
+		}
+		return t.String(), nil
+
+	case TypeBytes:
+		if data == nil {
+			return nil, nil
+		}
+		return base64.StdEncoding.EncodeToString(data), nil
+
+	case TypeFile:
+		file := map[string]any{}
+		for _, key := range []string{"filename", "mimeType", "encoding"} {
+			if v, ok := valueInfo[key]; ok {
+				file[key] = v
+			}
+		}
+		if data != nil {
+			file["data"] = base64.StdEncoding.EncodeToString(data)
+		}
+		return file, nil
+
+	default:
+		return value, nil
+	}
+}
+
+func decodeJSONString(value any) (any, error) {
+	s, ok := value.(string)
+	if !ok {
+		// Already deserialized by the engine
+		return value, nil
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+		return nil, fmt.Errorf("decode JSON value: %w", err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,130 @@
+package fluxnova
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeVariableValue(t *testing.T) {
+	custom, err := NewDateLayouts("dd.MM.yyyy HH:mm:ss")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		typ       string
+		value     any
+		valueInfo map[string]any
+		data      []byte
+		layouts   DateLayouts
+		want      any
+		wantErr   bool
+	}{
+		{
+			name:  "string",
+			typ:   TypeString,
+			value: `{"not": "decoded"}`,
+			want:  `{"not": "decoded"}`,
+		},
+		{
+			name:  "number",
+			typ:   TypeLong,
+			value: 42.0,
+			want:  42.0,
+		},
+		{
+			name:  "json",
+			typ:   TypeJSON,
+			value: `{"churnScore": 0.8, "signals": ["late payment"]}`,
+			want:  map[string]any{"churnScore": 0.8, "signals": []any{"late payment"}},
+		},
+		{
+			name:  "json already deserialized",
+			typ:   TypeJSON,
+			value: map[string]any{"churnScore": 0.8},
+			want:  map[string]any{"churnScore": 0.8},
+		},
+		{
+			name:    "invalid json",
+			typ:     TypeJSON,
+			value:   `{"churnScore":`,
+			wantErr: true,
+		},
+		{
+			name:      "object serialized as json",
+			typ:       TypeObject,
+			value:     `{"id": "C-1"}`,
+			valueInfo: map[string]any{"objectTypeName": "com.example.Customer", "serializationDataFormat": "application/json"},
+			want:      map[string]any{"id": "C-1"},
+		},
+		{
+			name:      "object in another format",
+			typ:       TypeObject,
+			value:     "rO0ABXNy",
+			valueInfo: map[string]any{"serializationDataFormat": "application/x-java-serialized-object"},
+			want:      "rO0ABXNy",
+		},
+		{
+			name:  "date",
+			typ:   TypeDate,
+			value: "2024-06-15T12:00:01.310+0200",
+			want:  "2024-06-15T10:00:01.310000000Z",
+		},
+		{
+			name:    "date in the engine's format",
+			typ:     TypeDate,
+			value:   "15.06.2024 10:00:01",
+			layouts: custom,
+			want:    "2024-06-15T10:00:01.000000000Z",
+		},
+		{
+			name:    "unparseable date",
+			typ:     TypeDate,
+			value:   "yesterday",
+			wantErr: true,
+		},
+		{
+			name: "bytes without data",
+			typ:  TypeBytes,
+			want: nil,
+		},
+		{
+			name: "bytes",
+			typ:  TypeBytes,
+			data: []byte("%PDF"),
+			want: "JVBERg==",
+		},
+		{
+			name:      "file",
+			typ:       TypeFile,
+			valueInfo: map[string]any{"filename": "invoice.pdf", "mimeType": "application/pdf", "other": "ignored"},
+			data:      []byte("%PDF"),
+			want:      map[string]any{"filename": "invoice.pdf", "mimeType": "application/pdf", "data": "JVBERg=="},
+		},
+		{
+			name:      "file without data",
+			typ:       TypeFile,
+			valueInfo: map[string]any{"filename": "invoice.pdf"},
+			want:      map[string]any{"filename": "invoice.pdf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeVariableValue(tt.typ, tt.value, tt.valueInfo, tt.data, tt.layouts)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("DecodeVariableValue() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeVariableValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
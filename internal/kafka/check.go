@@ -0,0 +1,27 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// CheckTopics connects to every broker and checks that the topics exist
+func CheckTopics(ctx context.Context, brokers []string, topics ...string) error {
+	for _, broker := range brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			return fmt.Errorf("broker %s: %w", broker, err)
+		}
+
+		for _, topic := range topics {
+			if _, err := conn.ReadPartitions(topic); err != nil {
+				conn.Close()
+				return fmt.Errorf("topic %s on broker %s: %w", topic, broker, err)
+			}
+		}
+		conn.Close()
+	}
+	return nil
+}
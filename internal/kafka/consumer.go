@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Consumer reads messages from a Kafka topic as part of a consumer group
+type Consumer struct {
+	reader *kafka.Reader
+}
+
+// NewConsumer creates a new Kafka consumer
+func NewConsumer(brokers []string, topic, groupID string) *Consumer {
+	return &Consumer{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			Topic:   topic,
+			GroupID: groupID,
+		}),
+	}
+}
+
+// Consume calls handle for each message until the context is cancelled.
+// Offsets are committed only after handle returns, so messages are
+// redelivered if the consumer stops mid-message.
+func (c *Consumer) Consume(ctx context.Context, handle func(key, value []byte) error) error {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := handle(msg.Key, msg.Value); err != nil {
+			return err
+		}
+
+		if err := c.reader.CommitMessages(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Close closes the Kafka reader
+func (c *Consumer) Close() error {
+	return c.reader.Close()
+}
@@ -3,8 +3,9 @@ package kafka
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"sync"
 
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -12,10 +13,18 @@ import (
 type Producer struct {
 	eventsWriter    *kafka.Writer
 	processesWriter *kafka.Writer
+	variablesWriter *kafka.Writer
+	purgesWriter    *kafka.Writer
+	breachesWriter  *kafka.Writer
+	// routedWriter has no topic of its own; each message names one
+	routedWriter *kafka.Writer
+
+	// inflight is read-locked by each send, so Flush can wait for them
+	inflight sync.RWMutex
 }
 
 // NewProducer creates a new Kafka producer
-func NewProducer(brokers []string, eventsTopic, processesTopic string) *Producer {
+func NewProducer(brokers []string, eventsTopic, processesTopic, variablesTopic, purgesTopic, breachesTopic string) *Producer {
 	return &Producer{
 		eventsWriter: &kafka.Writer{
 			Addr:     kafka.TCP(brokers...),
@@ -27,46 +36,121 @@ func NewProducer(brokers []string, eventsTopic, processesTopic string) *Producer
 			Topic:    processesTopic,
 			Balancer: &kafka.LeastBytes{},
 		},
+		variablesWriter: &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Topic:    variablesTopic,
+			Balancer: &kafka.LeastBytes{},
+		},
+		purgesWriter: &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Topic:    purgesTopic,
+			Balancer: &kafka.LeastBytes{},
+		},
+		breachesWriter: &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Topic:    breachesTopic,
+			Balancer: &kafka.LeastBytes{},
+		},
+		routedWriter: &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Balancer: &kafka.LeastBytes{},
+		},
 	}
 }
 
 // SendEvent sends an event to the events topic
 func (p *Producer) SendEvent(ctx context.Context, key string, event any) error {
-	data, err := json.Marshal(event)
+	return p.send(ctx, p.eventsWriter, "", key, event)
+}
+
+// SendProcess sends a process instance to the processes topic
+func (p *Producer) SendProcess(ctx context.Context, key string, process any) error {
+	return p.send(ctx, p.processesWriter, "", key, process)
+}
+
+// SendVariables sends a process variable snapshot to the variables topic
+func (p *Producer) SendVariables(ctx context.Context, key string, variables any) error {
+	return p.send(ctx, p.variablesWriter, "", key, variables)
+}
+
+// SendPurge sends a purged-in-source record to the purges topic
+func (p *Producer) SendPurge(ctx context.Context, key string, purge any) error {
+	return p.send(ctx, p.purgesWriter, "", key, purge)
+}
+
+// SendBreach sends an SLA breach record to the breaches topic
+func (p *Producer) SendBreach(ctx context.Context, key string, breach any) error {
+	return p.send(ctx, p.breachesWriter, "", key, breach)
+}
+
+// SendTo sends a record to the given topic, for records routed away from
+// their usual topic
+func (p *Producer) SendTo(ctx context.Context, topic, key string, record any) error {
+	return p.send(ctx, p.routedWriter, topic, key, record)
+}
+
+// send writes value as JSON to writer under key. topic is only set for the
+// routed writer, which has no topic of its own
+func (p *Producer) send(ctx context.Context, writer *kafka.Writer, topic, key string, value any) error {
+	data, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
 
 	msg := kafka.Message{
+		Topic: topic,
 		Key:   []byte(key),
 		Value: data,
 	}
 
-	if err := p.eventsWriter.WriteMessages(ctx, msg); err != nil {
+	p.inflight.RLock()
+	defer p.inflight.RUnlock()
+	if err := writer.WriteMessages(ctx, msg); err != nil {
 		return err
 	}
 
-	log.Printf("Sent event to Kafka: %s", key)
+	if topic == "" {
+		topic = writer.Topic
+	}
+	logging.FromContext(ctx).Debug("Sent record to Kafka", "topic", topic, "key", key)
 	return nil
 }
 
-// SendProcess sends a process instance to the processes topic
-func (p *Producer) SendProcess(ctx context.Context, key string, process any) error {
-	data, err := json.Marshal(process)
-	if err != nil {
-		return err
-	}
-
-	msg := kafka.Message{
-		Key:   []byte(key),
-		Value: data,
-	}
+// DeleteProcess sends a tombstone for a process instance to the processes
+// and variables topics, and for each of eventKeys to the events topic, which
+// the XTDB sink applies as deletes
+func (p *Producer) DeleteProcess(ctx context.Context, key string, eventKeys []string) error {
+	msg := kafka.Message{Key: []byte(key)}
+	p.inflight.RLock()
+	defer p.inflight.RUnlock()
 
 	if err := p.processesWriter.WriteMessages(ctx, msg); err != nil {
 		return err
 	}
+	if err := p.variablesWriter.WriteMessages(ctx, msg); err != nil {
+		return err
+	}
+	if len(eventKeys) > 0 {
+		msgs := make([]kafka.Message, len(eventKeys))
+		for i, eventKey := range eventKeys {
+			msgs[i] = kafka.Message{Key: []byte(eventKey)}
+		}
+		if err := p.eventsWriter.WriteMessages(ctx, msgs...); err != nil {
+			return err
+		}
+	}
 
-	log.Printf("Sent process to Kafka: %s", key)
+	logging.FromContext(ctx).Debug("Sent process tombstone to Kafka",
+		"topics", []string{p.processesWriter.Topic, p.variablesWriter.Topic}, "key", key, "events", len(eventKeys))
+	return nil
+}
+
+// Flush returns once the sends in progress have finished. Each send
+// returns once Kafka has acknowledged its messages, or with the error that
+// lost them, so there is nothing else to wait for.
+func (p *Producer) Flush(ctx context.Context) error {
+	p.inflight.Lock()
+	p.inflight.Unlock()
 	return nil
 }
 
@@ -75,5 +159,17 @@ func (p *Producer) Close() error {
 	if err := p.eventsWriter.Close(); err != nil {
 		return err
 	}
-	return p.processesWriter.Close()
+	if err := p.processesWriter.Close(); err != nil {
+		return err
+	}
+	if err := p.variablesWriter.Close(); err != nil {
+		return err
+	}
+	if err := p.purgesWriter.Close(); err != nil {
+		return err
+	}
+	if err := p.breachesWriter.Close(); err != nil {
+		return err
+	}
+	return p.routedWriter.Close()
 }
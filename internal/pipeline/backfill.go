@@ -0,0 +1,272 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/sink"
+)
+
+// BackfillOptions configures a historical backfill
+type BackfillOptions struct {
+	// SourceID selects the source to backfill; empty means the first source
+	SourceID string
+	// From and To bound the process instance start times to walk
+	From time.Time
+	To   time.Time
+	// Window is the width of each startedAfter/startedBefore query window
+	Window time.Duration
+	// Rate limits publishing to this many process instances per second
+	// (0 means unlimited)
+	Rate float64
+	// CheckpointFile records progress so an interrupted backfill can resume
+	CheckpointFile string
+}
+
+// backfillCheckpoint is the on-disk progress of a backfill
+type backfillCheckpoint struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	CarryOver   int       `json:"carry_over_done"`
+	WindowStart time.Time `json:"window_start"`
+	FirstResult int       `json:"first_result"`
+	Published   int       `json:"published"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Backfill walks a source's history between opts.From and opts.To in
+// windows of process start time and publishes it through the pipeline.
+// Instances started before From that were still running at From are
+// published first, so that everything running during the range is captured.
+// The engine's window bounds are inclusive, so instances starting exactly on
+// a boundary may be published twice; the records are identical.
+func (p *Pipeline) Backfill(ctx context.Context, opts BackfillOptions) error {
+	defer p.closeSink()
+	defer p.closeTracer()
+
+	if !opts.To.After(opts.From) {
+		return fmt.Errorf("backfill range is empty: --to must be after --from")
+	}
+	if opts.Window <= 0 {
+		return fmt.Errorf("backfill window must be positive")
+	}
+
+	src, err := p.source(opts.SourceID)
+	if err != nil {
+		return err
+	}
+	if src.cfg.BaseURL == "" {
+		return fmt.Errorf("source %s has no base_url to backfill from", src.cfg.ID)
+	}
+	if err := src.client.Ping(); err != nil {
+		return fmt.Errorf("failed to connect to Fluxnova source %s: %w", src.cfg.ID, err)
+	}
+
+	// Backfill always walks the REST history API, whatever the source's
+	// continuous capture uses
+	poller := fluxnova.NewPoller(src.client, p.currentConfig().Pipeline.BatchSize)
+	poller.SetFetchBinaryVariables(p.currentConfig().Pipeline.FetchBinaryVariables)
+	poller.SetTenantFilter(src.cfg.TenantIDs, src.cfg.WithoutTenantID)
+	poller.SetProcessDefinitionFilter(definitionKeyFilter(p.currentConfig().Pipeline.Filters.ProcessDefinitions))
+
+	progress, err := loadBackfillCheckpoint(opts)
+	if err != nil {
+		return err
+	}
+	if progress.Published > 0 {
+		src.logger.Info("Resuming backfill", "window_start", progress.WindowStart, "published", progress.Published)
+	}
+
+	throttle := newThrottle(opts.Rate)
+	started := time.Now()
+	totalWindows := int((opts.To.Sub(opts.From) + opts.Window - 1) / opts.Window)
+
+	// Instances that were already running at From: those finished since,
+	// and those still running
+	carryOver := []fluxnova.ProcessInstanceQuery{
+		{StartedBefore: &opts.From, FinishedAfter: &opts.From},
+		{StartedBefore: &opts.From, Unfinished: true},
+	}
+	for progress.CarryOver < len(carryOver) {
+		if err := p.backfillQuery(ctx, src, poller, carryOver[progress.CarryOver], &progress, opts, throttle); err != nil {
+			return err
+		}
+		progress.CarryOver++
+		progress.FirstResult = 0
+		if err := p.saveBackfillProgress(ctx, opts, progress); err != nil {
+			return err
+		}
+		if progress.CarryOver == len(carryOver) {
+			src.logger.Info("Backfilled instances already running", "running_at", opts.From, "published", progress.Published)
+		}
+	}
+
+	for progress.WindowStart.Before(opts.To) {
+		windowStart := progress.WindowStart
+		windowEnd := windowStart.Add(opts.Window)
+		if windowEnd.After(opts.To) {
+			windowEnd = opts.To
+		}
+
+		q := fluxnova.ProcessInstanceQuery{StartedAfter: &windowStart, StartedBefore: &windowEnd}
+		if err := p.backfillQuery(ctx, src, poller, q, &progress, opts, throttle); err != nil {
+			return err
+		}
+
+		progress.WindowStart = windowEnd
+		progress.FirstResult = 0
+		if err := p.saveBackfillProgress(ctx, opts, progress); err != nil {
+			return err
+		}
+
+		done := int((windowEnd.Sub(opts.From) + opts.Window - 1) / opts.Window)
+		src.logger.Info("Backfill window complete",
+			"window", done, "windows", totalWindows,
+			"window_start", windowStart, "window_end", windowEnd,
+			"published", progress.Published, "elapsed", time.Since(started).Round(time.Second).String())
+	}
+
+	src.logger.Info("Backfill complete", "published", progress.Published)
+	return nil
+}
+
+// backfillQuery pages through every instance matching q, saving progress
+// after each page
+func (p *Pipeline) backfillQuery(ctx context.Context, src *source, poller *fluxnova.Poller, q fluxnova.ProcessInstanceQuery, progress *backfillCheckpoint, opts BackfillOptions, throttle *throttle) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		q.FirstResult = progress.FirstResult
+		batchCtx := src.batchContext(ctx)
+		events, err := poller.PollQuery(batchCtx, q)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		// A failed page stops the backfill at the progress saved before it,
+		// so resuming publishes the page again
+		if err := p.publish(batchCtx, src, events); err != nil {
+			return err
+		}
+		progress.FirstResult += len(events)
+		progress.Published += len(events)
+		if err := p.saveBackfillProgress(batchCtx, opts, *progress); err != nil {
+			return err
+		}
+
+		if err := throttle.wait(ctx, len(events)); err != nil {
+			return err
+		}
+	}
+}
+
+// saveBackfillProgress flushes the sink and then saves the backfill's
+// progress. While the sink holds records back (sink.ErrPending) the saved
+// progress is left as it was, so resuming may publish some pages again.
+func (p *Pipeline) saveBackfillProgress(ctx context.Context, opts BackfillOptions, progress backfillCheckpoint) error {
+	if opts.CheckpointFile == "" {
+		return nil
+	}
+	err := p.sink.Flush(ctx)
+	if errors.Is(err, sink.ErrPending) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("flush sink: %w", err)
+	}
+	return saveBackfillCheckpoint(opts.CheckpointFile, progress)
+}
+
+// source returns the source with the given id, or the first source if id is
+// empty
+func (p *Pipeline) source(id string) (*source, error) {
+	if id == "" {
+		return p.sources[0], nil
+	}
+	for _, src := range p.sources {
+		if src.cfg.ID == id {
+			return src, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown source %q", id)
+}
+
+// loadBackfillCheckpoint returns saved progress for the same range, or fresh
+// progress starting at opts.From
+func loadBackfillCheckpoint(opts BackfillOptions) (backfillCheckpoint, error) {
+	fresh := backfillCheckpoint{From: opts.From, To: opts.To, WindowStart: opts.From}
+	if opts.CheckpointFile == "" {
+		return fresh, nil
+	}
+
+	data, err := os.ReadFile(opts.CheckpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return fresh, nil
+	}
+	if err != nil {
+		return fresh, err
+	}
+
+	var cp backfillCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fresh, fmt.Errorf("read backfill checkpoint: %w", err)
+	}
+	if !cp.From.Equal(opts.From) || !cp.To.Equal(opts.To) {
+		return fresh, fmt.Errorf("backfill checkpoint %s is for range %s to %s; remove it to start a new backfill",
+			opts.CheckpointFile, cp.From.Format(time.RFC3339), cp.To.Format(time.RFC3339))
+	}
+	return cp, nil
+}
+
+func saveBackfillCheckpoint(path string, cp backfillCheckpoint) error {
+	if path == "" {
+		return nil
+	}
+	cp.UpdatedAt = time.Now().UTC()
+	return writeJSONFile(path, cp)
+}
+
+// throttle limits the rate at which instances are published
+type throttle struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newThrottle(rate float64) *throttle {
+	if rate <= 0 {
+		return &throttle{}
+	}
+	return &throttle{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until n more instances may be published
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t.interval == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(n) * t.interval)
+
+	timer := time.NewTimer(time.Until(t.next))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import "container/list"
+
+// maxTrackedInstances bounds the per-instance state a source keeps in
+// memory. Instances whose end is never seen would otherwise be kept forever.
+const maxTrackedInstances = 100000
+
+// instanceCache maps process instance ids to values, holding at most max
+// entries. Adding one beyond that evicts the least recently used entry.
+type instanceCache[V any] struct {
+	max   int
+	order *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type cacheEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newInstanceCache[V any](max int) *instanceCache[V] {
+	return &instanceCache[V]{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get returns the value for key and marks it as used
+func (c *instanceCache[V]) Get(key string) (V, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry[V]).value, true
+}
+
+// Set stores the value for key, evicting the least recently used entry if
+// the cache is full
+func (c *instanceCache[V]) Set(key string, value V) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry[V]).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry[V]{key: key, value: value})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry[V]).key)
+	}
+}
+
+// Delete removes key
+func (c *instanceCache[V]) Delete(key string) {
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of entries
+func (c *instanceCache[V]) Len() int {
+	return c.order.Len()
+}
+
+// Keys returns the keys, most recently used first, without marking them as
+// used
+func (c *instanceCache[V]) Keys() []string {
+	keys := make([]string, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*cacheEntry[V]).key)
+	}
+	return keys
+}
+
+// Peek returns the value for key without marking it as used
+func (c *instanceCache[V]) Peek(key string) (V, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return el.Value.(*cacheEntry[V]).value, true
+}
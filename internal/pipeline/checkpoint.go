@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpoint is the on-disk state of a source: where polling resumes and
+// which process instances have been published (for reconciliation)
+type checkpoint struct {
+	LastPollTime *time.Time `json:"last_poll_time,omitempty"`
+	// LastID is the id of the last instance a database source read at
+	// LastPollTime
+	LastID string                    `json:"last_id,omitempty"`
+	Known  map[string]*knownInstance `json:"known,omitempty"`
+	// KnownInstances is the list of known instances checkpoints were saved
+	// with before their end times were tracked. It is only read.
+	KnownInstances []string  `json:"known_instances,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// loadCheckpoint reads a checkpoint, returning nil if the file doesn't exist
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// saveCheckpoint atomically writes a checkpoint
+func saveCheckpoint(path string, cp checkpoint) error {
+	cp.UpdatedAt = time.Now().UTC()
+	return writeJSONFile(path, cp)
+}
+
+// writeJSONFile atomically replaces path with the JSON encoding of v
+func writeJSONFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
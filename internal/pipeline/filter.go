@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+)
+
+// filterEvent applies the pipeline filters to an event. It returns false if
+// the event's process instance is filtered out; otherwise the returned event
+// has the filtered-out activities removed.
+//
+// Pushed activity and variable events don't carry the business key, so a
+// stream source remembers the instances it filtered out when their start
+// was pushed and drops their later events too.
+func (p *Pipeline) filterEvent(src *source, event fluxnova.ProcessEvent) (fluxnova.ProcessEvent, bool) {
+	filters := p.currentConfig().Pipeline.Filters
+
+	if _, ok := src.excluded.Get(event.ProcessInstanceID); ok {
+		if event.EventType == fluxnova.EventTypeProcessInstance && event.EndTime != nil {
+			src.excluded.Delete(event.ProcessInstanceID)
+		}
+		return event, false
+	}
+
+	pass := matchDefinition(filters.ProcessDefinitions, event.ProcessDefinition, event.DefinitionVersion) &&
+		matchFilter(filters.Tenants, deref(event.TenantID))
+	if event.EventType == fluxnova.EventTypeProcessInstance {
+		pass = pass && matchFilter(filters.BusinessKeys, deref(event.BusinessKey))
+		if !pass && event.EndTime == nil && src.pushed() {
+			src.excluded.Set(event.ProcessInstanceID, struct{}{})
+		}
+	}
+	if !pass {
+		return event, false
+	}
+
+	if len(filters.ActivityTypes.Include) > 0 || len(filters.ActivityTypes.Exclude) > 0 {
+		activities := make([]fluxnova.HistoricActivityInstance, 0, len(event.Activities))
+		for _, act := range event.Activities {
+			if matchFilter(filters.ActivityTypes, act.ActivityType) {
+				activities = append(activities, act)
+			}
+		}
+		event.Activities = activities
+	}
+	return event, true
+}
+
+// matchFilter reports whether value passes f
+func matchFilter(f config.Filter, value string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, value) {
+		return false
+	}
+	return !matchAny(f.Exclude, value)
+}
+
+// matchDefinition is matchFilter for process definitions, whose patterns
+// match the key, or key:version if they contain a colon
+func matchDefinition(f config.Filter, key string, version int) bool {
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			value := key
+			if strings.Contains(pattern, ":") {
+				value = key + ":" + strconv.Itoa(version)
+			}
+			if ok, _ := path.Match(pattern, value); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(f.Include) > 0 && !match(f.Include) {
+		return false
+	}
+	return !match(f.Exclude)
+}
+
+// definitionKeyFilter returns the process definition keys a poller query
+// can be restricted to and the keys it can leave out, for the patterns of f
+// that are plain keys. The pipeline still applies the whole filter.
+func definitionKeyFilter(f config.Filter) (keys, excluded []string) {
+	for _, pattern := range f.Include {
+		if !plainPattern(pattern) {
+			// One pattern the query can't express means it can't restrict
+			// the keys at all
+			keys = nil
+			break
+		}
+		keys = append(keys, pattern)
+	}
+	for _, pattern := range f.Exclude {
+		if plainPattern(pattern) {
+			excluded = append(excluded, pattern)
+		}
+	}
+	return keys, excluded
+}
+
+// plainPattern reports whether a pattern matches only itself as a process
+// definition key or activity id, so a query can filter on it directly. A
+// colon makes a definition pattern match key:version, which queries can't
+// express, and appears in no key or id.
+func plainPattern(pattern string) bool {
+	return !strings.ContainsAny(pattern, `*?[\:`)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+)
+
+func TestFilterEvent(t *testing.T) {
+	ptr := func(s string) *string { return &s }
+	end := &fluxnova.Time{}
+	activities := []fluxnova.HistoricActivityInstance{
+		{ID: "a1", ActivityType: "startEvent"},
+		{ID: "a2", ActivityType: "exclusiveGateway"},
+		{ID: "a3", ActivityType: "userTask"},
+	}
+	start := fluxnova.ProcessEvent{
+		EventType:         fluxnova.EventTypeProcessInstance,
+		ProcessInstanceID: "pi-1",
+		ProcessDefinition: "loan-approval",
+		DefinitionVersion: 3,
+		BusinessKey:       ptr("B-1"),
+		TenantID:          ptr("retail"),
+		Activities:        activities,
+	}
+	activity := fluxnova.ProcessEvent{
+		EventType:         fluxnova.EventTypeActivityInstance,
+		ProcessInstanceID: "pi-1",
+		ProcessDefinition: "loan-approval",
+		DefinitionVersion: 3,
+		TenantID:          ptr("retail"),
+	}
+	ended := start
+	ended.EndTime = end
+
+	tests := []struct {
+		name    string
+		filters config.FiltersConfig
+		source  string
+		events  []fluxnova.ProcessEvent
+		// want is whether each event passes
+		want []bool
+		// wantActivities are the activity ids left on the first event
+		wantActivities []string
+	}{
+		{
+			name:           "no filters",
+			events:         []fluxnova.ProcessEvent{start},
+			want:           []bool{true},
+			wantActivities: []string{"a1", "a2", "a3"},
+		},
+		{
+			name:    "definition included",
+			filters: config.FiltersConfig{ProcessDefinitions: config.Filter{Include: []string{"loan-*"}}},
+			events:  []fluxnova.ProcessEvent{start},
+			want:    []bool{true},
+		},
+		{
+			name:    "definition version excluded",
+			filters: config.FiltersConfig{ProcessDefinitions: config.Filter{Exclude: []string{"loan-approval:3"}}},
+			events:  []fluxnova.ProcessEvent{start},
+			want:    []bool{false},
+		},
+		{
+			name:    "other definition version",
+			filters: config.FiltersConfig{ProcessDefinitions: config.Filter{Exclude: []string{"loan-approval:[12]"}}},
+			events:  []fluxnova.ProcessEvent{start},
+			want:    []bool{true},
+		},
+		{
+			name:    "tenant not included",
+			filters: config.FiltersConfig{Tenants: config.Filter{Include: []string{"wealth"}}},
+			events:  []fluxnova.ProcessEvent{start, activity},
+			want:    []bool{false, false},
+		},
+		{
+			name:           "activity types",
+			filters:        config.FiltersConfig{ActivityTypes: config.Filter{Exclude: []string{"*Gateway"}}},
+			events:         []fluxnova.ProcessEvent{start},
+			want:           []bool{true},
+			wantActivities: []string{"a1", "a3"},
+		},
+		{
+			name:    "business key on a polled source",
+			filters: config.FiltersConfig{BusinessKeys: config.Filter{Exclude: []string{"B-*"}}},
+			events:  []fluxnova.ProcessEvent{start, activity},
+			want:    []bool{false, true},
+		},
+		{
+			name:    "business key on a pushed source",
+			filters: config.FiltersConfig{BusinessKeys: config.Filter{Exclude: []string{"B-*"}}},
+			source:  config.SourceTypeWebhook,
+			// The activity is dropped with its instance until the instance ends
+			events: []fluxnova.ProcessEvent{start, activity, ended, activity},
+			want:   []bool{false, false, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Pipeline
+			p.cfg.Store(&config.Config{Pipeline: config.PipelineConfig{Filters: tt.filters}})
+			src := &source{
+				cfg:      config.SourceConfig{ID: "eu", Type: tt.source},
+				excluded: newInstanceCache[struct{}](maxTrackedInstances),
+			}
+
+			for i, event := range tt.events {
+				got, ok := p.filterEvent(src, event)
+				if ok != tt.want[i] {
+					t.Errorf("event %d: filterEvent() passed = %v, want %v", i, ok, tt.want[i])
+				}
+				if i == 0 && tt.wantActivities != nil {
+					var ids []string
+					for _, act := range got.Activities {
+						ids = append(ids, act.ID)
+					}
+					if !reflect.DeepEqual(ids, tt.wantActivities) {
+						t.Errorf("activities = %v, want %v", ids, tt.wantActivities)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDefinitionKeyFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		filter       config.Filter
+		wantKeys     []string
+		wantExcluded []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:         "plain keys",
+			filter:       config.Filter{Include: []string{"loan", "claim"}, Exclude: []string{"test"}},
+			wantKeys:     []string{"loan", "claim"},
+			wantExcluded: []string{"test"},
+		},
+		{
+			name:   "include glob",
+			filter: config.Filter{Include: []string{"loan", "claim-*"}},
+		},
+		{
+			name:   "include version",
+			filter: config.Filter{Include: []string{"loan:2"}},
+		},
+		{
+			name:         "exclude patterns",
+			filter:       config.Filter{Exclude: []string{"test", "tmp-?", "old:1", `esc\*`}},
+			wantExcluded: []string{"test"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, excluded := definitionKeyFilter(tt.filter)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(excluded, tt.wantExcluded) {
+				t.Errorf("excluded = %v, want %v", excluded, tt.wantExcluded)
+			}
+		})
+	}
+}
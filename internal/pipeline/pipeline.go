@@ -2,143 +2,577 @@ package pipeline
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/refset/fluxnova-decision-observability/internal/config"
 	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
-	"github.com/refset/fluxnova-decision-observability/internal/kafka"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+	"github.com/refset/fluxnova-decision-observability/internal/tracing"
 )
 
-// Pipeline orchestrates the CDC flow from Fluxnova to Kafka
+// Pipeline orchestrates the CDC flow from Fluxnova to a sink, Kafka unless
+// configured otherwise
 type Pipeline struct {
-	cfg      *config.Config
-	client   *fluxnova.Client
-	poller   *fluxnova.Poller
-	producer *kafka.Producer
+	sources []*source
+	sink    Sink
+	// tracer exports finished instances as spans; nil unless configured
+	tracer *tracing.Exporter
+
+	// The configuration and variable rules are replaced on reload
+	cfg        atomic.Pointer[config.Config]
+	vars       atomic.Pointer[variableTransform]
+	transforms atomic.Pointer[RecordTransform]
+	reloadMu   sync.Mutex
 }
 
-// New creates a new pipeline
+// New creates a new pipeline. The configuration must have been validated.
 func New(cfg *config.Config) (*Pipeline, error) {
-	client := fluxnova.NewClient(
-		cfg.Fluxnova.BaseURL,
-		cfg.Fluxnova.Username,
-		cfg.Fluxnova.Password,
-	)
+	vars, err := newVariableTransform(cfg.Pipeline.Variables)
+	if err != nil {
+		return nil, err
+	}
+	transforms, err := NewRecordTransform(cfg.Pipeline.Transforms)
+	if err != nil {
+		return nil, err
+	}
 
-	producer := kafka.NewProducer(
-		cfg.Kafka.Brokers,
-		cfg.Kafka.EventsTopic,
-		cfg.Kafka.ProcessesTopic,
-	)
+	var sources []*source
+	for _, sc := range cfg.EffectiveSources() {
+		src, err := newSource(sc, cfg.Pipeline)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
 
-	poller := fluxnova.NewPoller(client, cfg.Pipeline.BatchSize)
+	sink, err := NewSink(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Pipeline{
-		cfg:      cfg,
-		client:   client,
-		poller:   poller,
-		producer: producer,
-	}, nil
+	p := &Pipeline{
+		sources: sources,
+		sink:    sink,
+	}
+	if cfg.Tracing.Endpoint != "" {
+		p.tracer, err = tracing.NewExporter(cfg.Tracing.Endpoint, cfg.Tracing.Headers, cfg.Tracing.ServiceName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.cfg.Store(cfg)
+	p.vars.Store(vars)
+	p.transforms.Store(transforms)
+	return p, nil
+}
+
+// currentConfig returns the configuration in effect
+func (p *Pipeline) currentConfig() *config.Config {
+	return p.cfg.Load()
 }
 
-// Run starts the CDC pipeline
+// Run starts the CDC pipeline, polling every source concurrently
 func (p *Pipeline) Run(ctx context.Context) error {
-	log.Printf("Starting Fluxnova CDC pipeline")
-	log.Printf("  Fluxnova: %s", p.cfg.Fluxnova.BaseURL)
-	log.Printf("  Kafka: %v", p.cfg.Kafka.Brokers)
-	log.Printf("  Poll interval: %s", p.cfg.Pipeline.PollInterval)
+	slog.Info("Starting Fluxnova CDC pipeline", sinkDescription(p.currentConfig())...)
+	for _, src := range p.sources {
+		switch src.cfg.Type {
+		case config.SourceTypeWebhook:
+			src.logger.Info("Receiving history events", "listen_addr", src.cfg.Stream.ListenAddr, "path", src.cfg.Stream.Path)
+		case config.SourceTypeKafka:
+			src.logger.Info("Consuming history events", "topic", src.cfg.Stream.Topic)
+		case config.SourceTypeDatabase:
+			src.logger.Info("Polling engine database", "poll_interval", src.cfg.PollInterval.String())
+		default:
+			src.logger.Info("Polling history API", "base_url", src.cfg.BaseURL, "poll_interval", src.cfg.PollInterval.String())
+		}
+		if p.tracer != nil && !src.pushed() {
+			// Polls read each instance once, usually while it is still running
+			src.logger.Warn("Tracing only exports instances and activities already finished when polled; use a webhook or kafka source for complete traces")
+		}
+	}
 
 	// Check Fluxnova connectivity
-	if err := p.client.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to Fluxnova: %w", err)
+	for _, src := range p.sources {
+		if err := src.ping(ctx); err != nil {
+			return fmt.Errorf("failed to connect to Fluxnova source %s: %w", src.cfg.ID, err)
+		}
+		src.logger.Info("Connected to Fluxnova")
+	}
+
+	var wg sync.WaitGroup
+	for _, src := range p.sources {
+		wg.Add(1)
+		go func(src *source) {
+			defer wg.Done()
+			p.runSource(ctx, src)
+		}(src)
+	}
+	wg.Wait()
+
+	slog.Info("Shutting down pipeline")
+	for _, src := range p.sources {
+		src.close()
+	}
+	p.closeTracer()
+	return p.sink.Close()
+}
+
+// closeSink closes the sink, for commands that finish on their own
+func (p *Pipeline) closeSink() {
+	if err := p.sink.Close(); err != nil {
+		slog.Error("Failed to close sink", logging.Error(err))
 	}
-	log.Printf("Connected to Fluxnova")
+}
+
+// closeTracer sends the spans still queued for export
+func (p *Pipeline) closeTracer() {
+	if p.tracer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.tracer.Close(ctx); err != nil {
+		slog.Warn("Failed to export remaining spans", logging.Error(err))
+	}
+}
 
-	ticker := time.NewTicker(p.cfg.Pipeline.PollInterval)
-	defer ticker.Stop()
+// runSource captures a single source until the context is cancelled.
+// Pushed sources that fail, e.g. because the Kafka brokers or the listen
+// address are unavailable, are restarted with exponential backoff.
+func (p *Pipeline) runSource(ctx context.Context, src *source) {
+	ctx = logging.WithLogger(ctx, src.logger)
 
-	// Initial poll
-	if err := p.poll(ctx); err != nil {
-		log.Printf("Initial poll error: %v", err)
+	if !src.pushed() {
+		p.runPollSource(ctx, src)
+		return
 	}
 
+	// There are no polls to check SLAs after. The checks finish before
+	// runSource returns, so Run doesn't close the sink under them.
+	var slaChecks sync.WaitGroup
+	slaChecks.Add(1)
+	go func() {
+		defer slaChecks.Done()
+		p.runSLAChecks(ctx, src)
+	}()
+	defer slaChecks.Wait()
+
+	backoff := time.Second
+	for {
+		started := time.Now()
+		var err error
+		if src.cfg.Type == config.SourceTypeWebhook {
+			err = p.runWebhookSource(ctx, src)
+		} else {
+			err = p.runKafkaSource(ctx, src)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			// It ran fine for a while, so this is a new failure
+			backoff = time.Second
+		}
+		src.logger.Error("Source failed, restarting", "retry_in", backoff.String(), logging.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// runPollSource polls a source's history API until the context is cancelled
+func (p *Pipeline) runPollSource(ctx context.Context, src *source) {
+	// The first poll is immediate
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var schedule pollSchedule
+
+	// Reconciliation is disabled unless an interval is configured, and uses
+	// the REST API
+	var reconcileTicker *time.Ticker
+	var reconcileC <-chan time.Time
+	setReconcileInterval := func(interval time.Duration) {
+		if reconcileTicker != nil {
+			reconcileTicker.Stop()
+			reconcileTicker, reconcileC = nil, nil
+		}
+		if interval > 0 && src.cfg.BaseURL != "" {
+			reconcileTicker = time.NewTicker(interval)
+			reconcileC = reconcileTicker.C
+		}
+	}
+	reconcileInterval := p.currentConfig().Pipeline.Reconcile.Interval
+	setReconcileInterval(reconcileInterval)
+	defer func() { setReconcileInterval(0) }()
+
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Shutting down pipeline")
-			return p.producer.Close()
-		case <-ticker.C:
-			if err := p.poll(ctx); err != nil {
-				log.Printf("Poll error: %v", err)
+			return
+		case <-timer.C:
+			before := src.poller.GetCheckpoint()
+			polled, err := p.poll(ctx, src)
+			if err != nil {
+				src.logger.Error("Poll failed", logging.Error(err))
+			}
+			advanced := checkpointAdvanced(before, src.poller.GetCheckpoint())
+			wait := schedule.next(src.cfg.PollInterval, p.currentConfig().Pipeline, polled, advanced, err)
+			if wait > 0 {
+				// Checked once caught up rather than between the polls
+				// draining a backlog
+				if err := p.checkSLAs(ctx, src); err != nil {
+					src.logger.Error("SLA check failed", logging.Error(err))
+				}
+			}
+			timer.Reset(wait)
+		case <-reconcileC:
+			if err := p.reconcile(ctx, src); err != nil {
+				src.logger.Error("Reconcile failed", logging.Error(err))
+			}
+		case cfg := <-src.updates:
+			pcfg := p.currentConfig().Pipeline
+			if cfg.PollInterval != src.cfg.PollInterval || pcfg.AdaptivePolling != schedule.cfg {
+				src.cfg.PollInterval = cfg.PollInterval
+				timer.Reset(schedule.restart(src.cfg.PollInterval, pcfg))
+			}
+			src.applyPipelineConfig(pcfg)
+			if pcfg.Reconcile.Interval != reconcileInterval {
+				reconcileInterval = pcfg.Reconcile.Interval
+				setReconcileInterval(reconcileInterval)
 			}
 		}
 	}
 }
 
-func (p *Pipeline) poll(ctx context.Context) error {
-	events, err := p.poller.Poll(ctx)
+// poll publishes the next batch of a source's history and returns how many
+// process events it held. If any of its records aren't written the poller
+// is moved back, so the next poll reads the batch again rather than the
+// checkpoint skipping it.
+func (p *Pipeline) poll(ctx context.Context, src *source) (int, error) {
+	ctx = src.batchContext(ctx)
+	from := src.position()
+	events, err := src.poller.Poll(ctx)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if len(events) == 0 {
-		return nil
+		if src.unsaved {
+			// Records held back by the sink may have been written since
+			return 0, p.saveCheckpoint(ctx, src)
+		}
+		return 0, nil
+	}
+
+	logging.FromContext(ctx).Info("Polled process events from Fluxnova", "events", len(events))
+	if err := p.publish(ctx, src, events); err != nil {
+		src.rewind(from, events)
+		return 0, err
+	}
+	if err := p.saveCheckpoint(ctx, src); err != nil {
+		src.rewind(from, events)
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// pollSchedule picks the wait before a source's next poll: the source's
+// poll interval, or with adaptive polling an interval following the
+// engine's activity
+type pollSchedule struct {
+	// cfg is the adaptive polling configuration interval was chosen under
+	cfg      config.AdaptivePollingConfig
+	interval time.Duration
+}
+
+// next returns the wait after a poll that returned polled events, moving
+// the source's checkpoint forward if advanced, or failed with err
+func (s *pollSchedule) next(pollInterval time.Duration, pcfg config.PipelineConfig, polled int, advanced bool, err error) time.Duration {
+	s.cfg = pcfg.AdaptivePolling
+	if !s.cfg.Enabled {
+		return pollInterval
+	}
+	lo, hi := s.bounds(pollInterval)
+
+	switch {
+	case err == nil && polled >= pcfg.BatchSize && advanced:
+		// There is probably more waiting. A full batch that didn't move the
+		// checkpoint, e.g. more instances sharing a start time than fit in
+		// one, would only be polled again, so it waits like any other.
+		s.interval = 0
+		return 0
+	case err == nil && polled > 0:
+		s.interval = lo
+	case s.interval == 0:
+		s.interval = lo
+	default:
+		// Idle, or the engine is failing: back off
+		s.interval = min(2*s.interval, hi)
+	}
+	return s.interval
+}
+
+// restart returns the wait before the next poll after the poll interval or
+// adaptive polling configuration changed, starting over from the shortest
+// interval
+func (s *pollSchedule) restart(pollInterval time.Duration, pcfg config.PipelineConfig) time.Duration {
+	s.cfg = pcfg.AdaptivePolling
+	if !s.cfg.Enabled {
+		return pollInterval
 	}
+	s.interval, _ = s.bounds(pollInterval)
+	return s.interval
+}
 
-	log.Printf("Polled %d process events from Fluxnova", len(events))
+// bounds returns the adaptive interval's range, which always includes the
+// minimum
+func (s *pollSchedule) bounds(pollInterval time.Duration) (lo, hi time.Duration) {
+	lo = s.cfg.MinInterval
+	if lo == 0 {
+		lo = pollInterval
+	}
+	return lo, max(s.cfg.MaxInterval, lo)
+}
 
+// checkpointAdvanced reports whether a poll moved a source's checkpoint
+// from before to after
+func checkpointAdvanced(before, after *time.Time) bool {
+	if after == nil {
+		return false
+	}
+	return before == nil || after.After(*before)
+}
+
+// publish sends the records for events to the sink. Failures are logged per
+// record so one bad instance doesn't block the rest of the batch, and
+// reported in the returned error so the caller doesn't acknowledge the batch.
+func (p *Pipeline) publish(ctx context.Context, src *source, events []fluxnova.ProcessEvent) error {
+	var spans []tracing.Span
+	var firstErr error
+	failed := 0
 	for _, event := range events {
+		event, ok := p.filterEvent(src, event)
+		if !ok {
+			continue
+		}
+		if err := p.publishEvent(ctx, src, event); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+		}
+		p.observeSLAs(ctx, src, event)
+		if p.tracer != nil {
+			spans = append(spans, tracing.ProcessEventSpans(src.cfg.ID, event)...)
+		}
+	}
+	if p.tracer != nil {
+		p.tracer.Export(ctx, spans)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d events not fully published: %w", failed, len(events), firstErr)
+	}
+	return nil
+}
+
+// publishEvent sends the records for one event, returning the first send
+// that failed. Polled events carry a whole process instance; pushed history
+// events carry a single activity or variable change.
+func (p *Pipeline) publishEvent(ctx context.Context, src *source, event fluxnova.ProcessEvent) error {
+	if event.EventType == fluxnova.EventTypeVariableUpdate {
+		event.Variables = src.mergeLiveVariables(event)
+	}
+	event.Variables = p.vars.Load().Apply(event.ProcessDefinition, event.Variables)
+	logger := logging.FromContext(ctx).With("process_instance_id", event.ProcessInstanceID)
+
+	variablesAsOf, err := p.publishVariables(ctx, src, event)
+	if err != nil {
+		logger.Error("Failed to send variables", logging.Error(err))
+		return err
+	}
+
+	if event.EventType == fluxnova.EventTypeProcessInstance {
+		// Top-level instances are their own root
+		rootID := event.ProcessInstanceID
+		if event.RootProcessID != nil {
+			rootID = *event.RootProcessID
+		}
+
 		// Send process instance to processes topic
+		key := src.recordID(event.ProcessInstanceID)
 		processRecord := map[string]any{
-			"_id":                    event.ProcessInstanceID,
-			"process_instance_id":   event.ProcessInstanceID,
-			"process_definition_key": event.ProcessDefinition,
-			"business_key":          event.BusinessKey,
-			"state":                 event.State,
-			"start_time":            event.StartTime,
-			"end_time":              event.EndTime,
-			"duration_millis":       event.DurationMillis,
-			"variables":             event.Variables,
-			"_valid_from":           event.StartTime,
-		}
-
-		if err := p.producer.SendProcess(ctx, event.ProcessInstanceID, processRecord); err != nil {
-			log.Printf("Failed to send process %s: %v", event.ProcessInstanceID, err)
-			continue
+			"_id":                        key,
+			"process_instance_id":        event.ProcessInstanceID,
+			"process_definition_key":     event.ProcessDefinition,
+			"business_key":               event.BusinessKey,
+			"parent_process_instance_id": event.SuperProcessID,
+			"root_process_instance_id":   rootID,
+			"state":                      event.State,
+			"start_time":                 event.StartTime,
+			"end_time":                   event.EndTime,
+			"duration_millis":            event.DurationMillis,
+			"_valid_from":                event.StartTime,
 		}
+		addVariablesRef(processRecord, src.recordID(event.ProcessInstanceID), variablesAsOf)
+		src.stamp(processRecord, event.TenantID)
 
-		// Send each activity as an event
-		for _, activity := range event.Activities {
-			activityRecord := map[string]any{
-				"_id":                  activity.ID,
-				"process_instance_id":  activity.ProcessInstanceID,
-				"activity_id":          activity.ActivityID,
-				"activity_name":        activity.ActivityName,
-				"activity_type":        activity.ActivityType,
-				"execution_id":         activity.ExecutionID,
-				"task_id":              activity.TaskID,
-				"assignee":             activity.Assignee,
-				"start_time":           activity.StartTime,
-				"end_time":             activity.EndTime,
-				"duration_millis":      activity.DurationInMillis,
-				"canceled":             activity.Canceled,
-				"_valid_from":          activity.StartTime,
+		if err := p.send(ctx, config.RecordProcess, key, processRecord); err != nil {
+			logger.Error("Failed to send process", logging.Error(err))
+			return err
+		}
+		if p.currentConfig().Pipeline.Reconcile.Interval > 0 {
+			if known := src.track(event.ProcessInstanceID); known != nil && event.EndTime != nil {
+				known.end(event.EndTime.Time)
 			}
+		}
+		if event.EndTime != nil {
+			src.liveVariables.Delete(event.ProcessInstanceID)
+		}
+	}
 
-			// Include process variables in the activity event for decision context
-			if len(event.Variables) > 0 {
-				varsJSON, _ := json.Marshal(event.Variables)
-				activityRecord["process_variables"] = string(varsJSON)
-			}
+	// Send each activity as an event
+	var activityErr error
+	for _, activity := range event.Activities {
+		key := src.recordID(activity.ID)
+		activityRecord := map[string]any{
+			"_id":                         key,
+			"activity_instance_id":        activity.ID,
+			"process_instance_id":         activity.ProcessInstanceID,
+			"process_definition_key":      event.ProcessDefinition,
+			"parent_activity_instance_id": activity.ParentActivityInstanceID,
+			"activity_id":                 activity.ActivityID,
+			"activity_name":               activity.ActivityName,
+			"activity_type":               activity.ActivityType,
+			"execution_id":                activity.ExecutionID,
+			"task_id":                     activity.TaskID,
+			"assignee":                    activity.Assignee,
+			"called_process_instance_id":  activity.CalledProcessInstanceID,
+			"start_time":                  activity.StartTime,
+			"end_time":                    activity.EndTime,
+			"duration_millis":             activity.DurationInMillis,
+			"canceled":                    activity.Canceled,
+			"complete_scope":              activity.CompleteScope,
+			"_valid_from":                 activity.StartTime,
+		}
 
-			if err := p.producer.SendEvent(ctx, activity.ID, activityRecord); err != nil {
-				log.Printf("Failed to send activity %s: %v", activity.ID, err)
+		// Reference the variable snapshot for decision context
+		addVariablesRef(activityRecord, src.recordID(event.ProcessInstanceID), variablesAsOf)
+		src.stamp(activityRecord, activity.TenantID)
+
+		if err := p.send(ctx, config.RecordActivity, key, activityRecord); err != nil {
+			logger.Error("Failed to send activity", "activity_instance_id", activity.ID, logging.Error(err))
+			if activityErr == nil {
+				activityErr = err
+			}
+			continue
+		}
+		if reconcile := p.currentConfig().Pipeline.Reconcile; reconcile.Interval > 0 && reconcile.Policy == config.PurgePolicyEndValidTime {
+			if known := src.track(activity.ProcessInstanceID); known != nil {
+				known.addEventKey(key)
 			}
 		}
 	}
+	return activityErr
+}
 
-	return nil
+// publishVariables sends the process's variables to the variables topic if
+// they changed since the last snapshot, including to an empty set, and
+// returns the valid time of the snapshot that is current for this event (""
+// if there are no variables)
+func (p *Pipeline) publishVariables(ctx context.Context, src *source, event fluxnova.ProcessEvent) (string, error) {
+	last, seen := src.snapshots.Get(event.ProcessInstanceID)
+	if event.EndTime != nil {
+		// Finished processes won't change again
+		defer src.snapshots.Delete(event.ProcessInstanceID)
+	}
+
+	// nil means the event says nothing about the variables, while an empty
+	// map means they were all removed, which replaces a published snapshot
+	if event.Variables == nil || (len(event.Variables) == 0 && !seen) {
+		if seen {
+			return fluxnova.NewTime(last.asOf).String(), nil
+		}
+		return "", nil
+	}
+
+	data, err := json.Marshal(event.Variables)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if seen && last.hash == hash {
+		return fluxnova.NewTime(last.asOf).String(), nil
+	}
+
+	var asOfTime time.Time
+	if event.VariablesTime != nil {
+		asOfTime = event.VariablesTime.UTC()
+	}
+	if asOfTime.IsZero() || (seen && !asOfTime.After(last.asOf)) {
+		// Updates to existing variables don't move createTime, so fall back
+		// to the time the change was observed
+		asOfTime = event.Timestamp.UTC()
+	}
+	asOfTime = asOfTime.Truncate(time.Millisecond)
+	asOf := fluxnova.NewTime(asOfTime).String()
+
+	key := src.recordID(event.ProcessInstanceID)
+	record := map[string]any{
+		"_id":                    key,
+		"process_instance_id":    event.ProcessInstanceID,
+		"process_definition_key": event.ProcessDefinition,
+		"variables":              event.Variables,
+		"_valid_from":            asOf,
+	}
+	src.stamp(record, event.TenantID)
+	if err := p.send(ctx, config.RecordVariables, key, record); err != nil {
+		return "", err
+	}
+
+	src.snapshots.Set(event.ProcessInstanceID, variableSnapshot{hash: hash, asOf: asOfTime})
+	return asOf, nil
+}
+
+// send applies the record transforms to a record and sends it to the topic
+// it is routed to, or the usual topic for its kind
+func (p *Pipeline) send(ctx context.Context, kind, key string, record map[string]any) error {
+	topic, err := p.transforms.Load().Apply(kind, record)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Record transform failed", "record", kind, "key", key, logging.Error(err))
+	}
+	if topic != "" {
+		return p.sink.SendTo(ctx, topic, key, record)
+	}
+
+	switch kind {
+	case config.RecordProcess:
+		return p.sink.SendProcess(ctx, key, record)
+	case config.RecordVariables:
+		return p.sink.SendVariables(ctx, key, record)
+	default:
+		return p.sink.SendEvent(ctx, key, record)
+	}
+}
+
+// addVariablesRef points a record at the variable snapshot with id
+// variablesID valid at asOf in fluxnova_variables
+func addVariablesRef(record map[string]any, variablesID, asOf string) {
+	if asOf == "" {
+		return
+	}
+	record["variables_id"] = variablesID
+	record["variables_as_of"] = asOf
 }
@@ -0,0 +1,300 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/sink"
+)
+
+func TestPollScheduleNext(t *testing.T) {
+	// poll is the outcome of one poll
+	type poll struct {
+		polled   int
+		advanced bool
+		err      error
+	}
+	full := poll{polled: 100, advanced: true}
+	stuck := poll{polled: 100}
+	partial := poll{polled: 5, advanced: true}
+	idle := poll{}
+	failed := poll{err: errors.New("engine unavailable")}
+
+	adaptive := config.AdaptivePollingConfig{Enabled: true, MinInterval: 2 * time.Second, MaxInterval: 10 * time.Second}
+
+	tests := []struct {
+		name     string
+		adaptive config.AdaptivePollingConfig
+		polls    []poll
+		want     []time.Duration
+	}{
+		{
+			name:  "disabled",
+			polls: []poll{full, idle, failed},
+			want:  []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:     "backlog drains without waiting",
+			adaptive: adaptive,
+			polls:    []poll{full, full, partial},
+			want:     []time.Duration{0, 0, 2 * time.Second},
+		},
+		{
+			name:     "full batch without progress waits",
+			adaptive: adaptive,
+			polls:    []poll{full, stuck, stuck},
+			want:     []time.Duration{0, 2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:     "idle backs off to the maximum",
+			adaptive: adaptive,
+			polls:    []poll{idle, idle, idle, idle, idle},
+			want:     []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second},
+		},
+		{
+			name:     "failures back off",
+			adaptive: adaptive,
+			polls:    []poll{full, failed, failed},
+			want:     []time.Duration{0, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:     "activity resets the backoff",
+			adaptive: adaptive,
+			polls:    []poll{idle, idle, partial, idle},
+			want:     []time.Duration{2 * time.Second, 4 * time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:     "minimum defaults to the poll interval",
+			adaptive: config.AdaptivePollingConfig{Enabled: true, MaxInterval: 12 * time.Second},
+			polls:    []poll{partial, idle, idle},
+			want:     []time.Duration{5 * time.Second, 10 * time.Second, 12 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pcfg := config.PipelineConfig{BatchSize: 100, AdaptivePolling: tt.adaptive}
+			var s pollSchedule
+			var got []time.Duration
+			for _, p := range tt.polls {
+				got = append(got, s.next(5*time.Second, pcfg, p.polled, p.advanced, p.err))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckpointAdvanced(t *testing.T) {
+	t1 := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Millisecond)
+	same := t1
+
+	tests := []struct {
+		name          string
+		before, after *time.Time
+		want          bool
+	}{
+		{"no checkpoint", nil, nil, false},
+		{"first checkpoint", nil, &t1, true},
+		{"moved forward", &t1, &t2, true},
+		{"unchanged", &t1, &same, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkpointAdvanced(tt.before, tt.after); got != tt.want {
+				t.Errorf("checkpointAdvanced() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishVariables(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// variables of each successive event for the same instance
+		variables []map[string]any
+		// variables of each snapshot published
+		want []map[string]any
+	}{
+		{
+			name:      "unchanged variables are published once",
+			variables: []map[string]any{{"amount": 10.0}, {"amount": 10.0}},
+			want:      []map[string]any{{"amount": 10.0}},
+		},
+		{
+			name:      "changed variables are published again",
+			variables: []map[string]any{{"amount": 10.0}, {"amount": 20.0}},
+			want:      []map[string]any{{"amount": 10.0}, {"amount": 20.0}},
+		},
+		{
+			name:      "removing every variable publishes an empty snapshot",
+			variables: []map[string]any{{"amount": 10.0}, {}, {}},
+			want:      []map[string]any{{"amount": 10.0}, {}},
+		},
+		{
+			name:      "no variables yet publishes nothing",
+			variables: []map[string]any{{}, nil},
+		},
+		{
+			name:      "unknown variables keep the snapshot",
+			variables: []map[string]any{{"amount": 10.0}, nil},
+			want:      []map[string]any{{"amount": 10.0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, src, sink := newTestPipeline(t, &config.Config{}, config.SourceConfig{})
+			for i, vars := range tt.variables {
+				event := fluxnova.ProcessEvent{
+					ProcessInstanceID: "pi-1",
+					ProcessDefinition: "invoice",
+					Variables:         vars,
+					Timestamp:         start.Add(time.Duration(i) * time.Second),
+				}
+				if _, err := p.publishVariables(context.Background(), src, event); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []map[string]any
+			for _, sent := range sink.sent() {
+				got = append(got, sent.record.(map[string]any)["variables"].(map[string]any))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("published %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// stubPoller returns the same events from every poll, moving its checkpoint
+// to next
+type stubPoller struct {
+	events     []fluxnova.ProcessEvent
+	next       time.Time
+	checkpoint *time.Time
+}
+
+func (s *stubPoller) Poll(ctx context.Context) ([]fluxnova.ProcessEvent, error) {
+	next := s.next
+	s.checkpoint = &next
+	return s.events, nil
+}
+
+func (s *stubPoller) SetCheckpoint(t *time.Time)                         { s.checkpoint = t }
+func (s *stubPoller) GetCheckpoint() *time.Time                          { return s.checkpoint }
+func (s *stubPoller) SetBatchSize(n int)                                 {}
+func (s *stubPoller) SetProcessDefinitionFilter(keys, excluded []string) {}
+
+func TestPollCheckpoint(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	next := start.Add(time.Minute)
+	failed := errors.New("broker unavailable")
+
+	tests := []struct {
+		name     string
+		sendErr  error
+		flushErr error
+		// wantCheckpoint is the poller's checkpoint after the poll
+		wantCheckpoint *time.Time
+		wantSaved      bool
+		wantErr        bool
+	}{
+		{name: "written", wantCheckpoint: &next, wantSaved: true},
+		{name: "send failed", sendErr: failed, wantCheckpoint: &start, wantErr: true},
+		{name: "flush failed", flushErr: failed, wantCheckpoint: &start, wantErr: true},
+		{name: "held back by the sink", flushErr: sink.ErrPending, wantCheckpoint: &next},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checkpoint.json")
+			p, src, rec := newTestPipeline(t, &config.Config{}, config.SourceConfig{CheckpointFile: path})
+			checkpoint := start
+			src.poller = &stubPoller{
+				events: []fluxnova.ProcessEvent{{
+					EventType:         fluxnova.EventTypeProcessInstance,
+					ProcessInstanceID: "pi-1",
+					ProcessDefinition: "invoice",
+					Variables:         map[string]any{"amount": 10.0},
+					Timestamp:         start,
+				}},
+				next:       next,
+				checkpoint: &checkpoint,
+			}
+			rec.err, rec.flushErr = tt.sendErr, tt.flushErr
+
+			polled, err := p.poll(context.Background(), src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("poll() error = %v, want an error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && polled != 0 {
+				t.Errorf("poll() = %d events, want 0 on failure", polled)
+			}
+			if got := src.poller.GetCheckpoint(); !got.Equal(*tt.wantCheckpoint) {
+				t.Errorf("checkpoint = %v, want %v", got, *tt.wantCheckpoint)
+			}
+			if _, err := os.Stat(path); (err == nil) != tt.wantSaved {
+				t.Errorf("checkpoint file saved = %v, want %v", err == nil, tt.wantSaved)
+			}
+			if tt.wantErr {
+				if _, ok := src.snapshots.Get("pi-1"); ok {
+					t.Error("variable snapshot kept after a failed poll, so it wouldn't be published again")
+				}
+			}
+		})
+	}
+}
+
+func TestPollSavesHeldBackCheckpoint(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	p, src, rec := newTestPipeline(t, &config.Config{}, config.SourceConfig{CheckpointFile: path})
+	poller := &stubPoller{
+		events: []fluxnova.ProcessEvent{{
+			EventType:         fluxnova.EventTypeProcessInstance,
+			ProcessInstanceID: "pi-1",
+			ProcessDefinition: "invoice",
+			Timestamp:         start,
+		}},
+		next: start,
+	}
+	src.poller = poller
+
+	rec.flushErr = sink.ErrPending
+	if _, err := p.poll(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("checkpoint saved while the sink held records back")
+	}
+
+	// An idle poll saves it once the sink has written them
+	poller.events = nil
+	rec.flushErr = nil
+	if _, err := p.poll(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.LastPollTime == nil || !cp.LastPollTime.Equal(start) {
+		t.Errorf("saved checkpoint = %+v, want last poll time %v", cp, start)
+	}
+	if src.unsaved {
+		t.Error("source still has an unsaved checkpoint")
+	}
+}
@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// knownInstance is what reconciliation keeps of a published process instance
+type knownInstance struct {
+	// EndTime is when the instance finished, once seen
+	EndTime *time.Time `json:"end_time,omitempty"`
+	// EventKeys are the keys of the activity records of a running instance,
+	// kept under the end_valid_time policy to delete them along with the
+	// instance
+	EventKeys []string `json:"event_keys,omitempty"`
+}
+
+// addEventKey records the key of an activity record of the instance, unless
+// it has ended
+func (k *knownInstance) addEventKey(key string) {
+	if k.EndTime == nil && !slices.Contains(k.EventKeys, key) {
+		k.EventKeys = append(k.EventKeys, key)
+	}
+}
+
+// end records that the instance finished at t. Its activity keys are
+// dropped, so the state kept per finished instance stays small until the
+// retention forgets it.
+func (k *knownInstance) end(t time.Time) {
+	k.EndTime = &t
+	k.EventKeys = nil
+}
+
+// track returns the source's reconciliation state for a process instance,
+// adding the instance if it isn't known yet. Only polling sources are
+// reconciled, so it returns nil for pushed ones.
+func (s *source) track(id string) *knownInstance {
+	if s.pushed() {
+		return nil
+	}
+	known, ok := s.known.Get(id)
+	if !ok {
+		known = &knownInstance{}
+		s.known.Set(id, known)
+	}
+	return known
+}
+
+// knownInstances returns the source's known instances for its checkpoint
+func (s *source) knownInstances() map[string]*knownInstance {
+	if s.known.Len() == 0 {
+		return nil
+	}
+	known := make(map[string]*knownInstance, s.known.Len())
+	for _, id := range s.known.Keys() {
+		known[id], _ = s.known.Peek(id)
+	}
+	return known
+}
+
+// reconcile checks the source's known process instances against the engine
+// and publishes a purge record for every instance that no longer exists,
+// e.g. because history cleanup removed it or an operator deleted it.
+// Instances that finished more than the retention ago are forgotten.
+func (p *Pipeline) reconcile(ctx context.Context, src *source) error {
+	if src.known.Len() == 0 {
+		return nil
+	}
+
+	ids := src.known.Keys()
+	sort.Strings(ids)
+
+	rcfg := p.currentConfig().Pipeline.Reconcile
+	batchSize := p.currentConfig().Pipeline.BatchSize
+	purged := 0
+	for start := 0; start < len(ids); start += batchSize {
+		end := min(start+batchSize, len(ids))
+		batch := ids[start:end]
+
+		existing, err := src.client.FindExistingProcessInstances(batch)
+		if err != nil {
+			return err
+		}
+		found := make(map[string]bool, len(existing))
+		for _, proc := range existing {
+			found[proc.ID] = true
+			// Polling sources only see an instance's start, so its end is
+			// usually learned here
+			if known, ok := src.known.Peek(proc.ID); ok && known.EndTime == nil && proc.EndTime != nil {
+				known.end(proc.EndTime.Time)
+			}
+		}
+
+		for _, id := range batch {
+			if found[id] {
+				continue
+			}
+			known, _ := src.known.Peek(id)
+			if err := p.publishPurge(ctx, src, id, known.EventKeys); err != nil {
+				src.logger.Error("Failed to publish purge", "process_instance_id", id, logging.Error(err))
+				continue
+			}
+			src.known.Delete(id)
+			src.snapshots.Delete(id)
+			purged++
+		}
+	}
+
+	forgotten := 0
+	if rcfg.Retention > 0 {
+		cutoff := time.Now().Add(-rcfg.Retention)
+		for _, id := range src.known.Keys() {
+			if known, _ := src.known.Peek(id); known.EndTime != nil && known.EndTime.Before(cutoff) {
+				src.known.Delete(id)
+				forgotten++
+			}
+		}
+	}
+
+	if purged > 0 {
+		src.logger.Info("Reconciliation found process instances removed from the engine", "purged", purged)
+	}
+	if forgotten > 0 {
+		src.logger.Debug("Stopped reconciling instances finished before the retention", "instances", forgotten)
+	}
+	if purged > 0 || forgotten > 0 {
+		return p.saveCheckpoint(ctx, src)
+	}
+	return nil
+}
+
+// publishPurge records that a process instance was removed from the engine
+// and, under the end_valid_time policy, deletes its records, including the
+// activity records with eventKeys
+func (p *Pipeline) publishPurge(ctx context.Context, src *source, processInstanceID string, eventKeys []string) error {
+	policy := p.currentConfig().Pipeline.Reconcile.Policy
+	detectedAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+	key := src.recordID(processInstanceID)
+	record := map[string]any{
+		"_id":                 key,
+		"process_instance_id": processInstanceID,
+		"engine_id":           src.cfg.ID,
+		"purged_in_source":    true,
+		"detected_at":         detectedAt,
+		"policy":              policy,
+		"_valid_from":         detectedAt,
+	}
+	if err := p.sink.SendPurge(ctx, key, record); err != nil {
+		return err
+	}
+
+	if policy == config.PurgePolicyEndValidTime {
+		return p.sink.DeleteProcess(ctx, key, eventKeys)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+)
+
+func TestTrackKnownInstances(t *testing.T) {
+	start := fluxnova.NewTime(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+	end := fluxnova.NewTime(time.Date(2024, 5, 1, 9, 5, 0, 0, time.UTC))
+	running := fluxnova.ProcessEvent{
+		EventType:         fluxnova.EventTypeProcessInstance,
+		ProcessInstanceID: "pi-1",
+		StartTime:         start,
+		Activities:        []fluxnova.HistoricActivityInstance{{ID: "act-1", ProcessInstanceID: "pi-1"}},
+	}
+	ended := running
+	ended.EndTime = &end
+	ended.Activities = []fluxnova.HistoricActivityInstance{{ID: "act-2", ProcessInstanceID: "pi-1"}}
+
+	tests := []struct {
+		name   string
+		source string
+		events []fluxnova.ProcessEvent
+		want   map[string]*knownInstance
+	}{
+		{
+			name:   "running instance keeps its activity keys",
+			source: config.SourceTypePoll,
+			events: []fluxnova.ProcessEvent{running},
+			want:   map[string]*knownInstance{"pi-1": {EventKeys: []string{"default/act-1"}}},
+		},
+		{
+			name:   "ended instance drops its activity keys",
+			source: config.SourceTypePoll,
+			events: []fluxnova.ProcessEvent{running, ended},
+			want:   map[string]*knownInstance{"pi-1": {EndTime: &end.Time}},
+		},
+		{
+			name:   "pushed sources aren't tracked",
+			source: config.SourceTypeWebhook,
+			events: []fluxnova.ProcessEvent{running},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Pipeline: config.PipelineConfig{
+				Reconcile: config.ReconcileConfig{Interval: time.Minute, Policy: config.PurgePolicyEndValidTime},
+			}}
+			p, src, _ := newTestPipeline(t, cfg, config.SourceConfig{Type: tt.source})
+			for _, event := range tt.events {
+				p.publishEvent(context.Background(), src, event)
+			}
+			if got := src.knownInstances(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("known instances = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+)
+
+// RecordTransform applies the configured record transforms to records
+// before they are published
+type RecordTransform struct {
+	transforms []compiledTransform
+}
+
+type compiledTransform struct {
+	name   string
+	kinds  []string
+	when   *vm.Program
+	fields []string
+	set    map[string]*vm.Program
+	topic  string
+}
+
+// NewRecordTransform compiles validated transforms
+func NewRecordTransform(cfgs []config.RecordTransform) (*RecordTransform, error) {
+	t := &RecordTransform{}
+	for i, cfg := range cfgs {
+		name := cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("transforms[%d]", i)
+		}
+		ct := compiledTransform{
+			name:  name,
+			kinds: cfg.Records,
+			set:   make(map[string]*vm.Program, len(cfg.Set)),
+			topic: cfg.Topic,
+		}
+
+		if cfg.When != "" {
+			program, err := expr.Compile(cfg.When, expr.AsBool())
+			if err != nil {
+				return nil, fmt.Errorf("transform %s: when: %w", name, err)
+			}
+			ct.when = program
+		}
+		for field, e := range cfg.Set {
+			if slices.Contains(config.ReservedRecordFields, field) {
+				return nil, fmt.Errorf("transform %s: set %s: reserved field", name, field)
+			}
+			program, err := expr.Compile(e)
+			if err != nil {
+				return nil, fmt.Errorf("transform %s: set %s: %w", name, field, err)
+			}
+			ct.fields = append(ct.fields, field)
+			ct.set[field] = program
+		}
+		sort.Strings(ct.fields)
+
+		t.transforms = append(t.transforms, ct)
+	}
+	return t, nil
+}
+
+// Apply runs the transforms for a record of the given kind, adding derived
+// fields to record, and returns the topic the record is routed to, or "" for
+// its usual topic. Expressions see the record as JSON would encode it. A
+// transform that fails to evaluate is skipped and reported in the error; the
+// others still apply.
+func (t *RecordTransform) Apply(kind string, record map[string]any) (string, error) {
+	if len(t.transforms) == 0 {
+		return "", nil
+	}
+
+	env, err := jsonEnv(record)
+	if err != nil {
+		return "", err
+	}
+
+	var topic string
+	var errs []error
+	for _, ct := range t.transforms {
+		if len(ct.kinds) > 0 && !slices.Contains(ct.kinds, kind) {
+			continue
+		}
+		if ct.when != nil {
+			matched, err := expr.Run(ct.when, env)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("transform %s: when: %w", ct.name, err))
+				continue
+			}
+			b, ok := matched.(bool)
+			if !ok {
+				errs = append(errs, fmt.Errorf("transform %s: when: got %v, want a bool", ct.name, matched))
+				continue
+			}
+			if !b {
+				continue
+			}
+		}
+
+		values := make(map[string]any, len(ct.fields))
+		failed := false
+		for _, field := range ct.fields {
+			value, err := expr.Run(ct.set[field], env)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("transform %s: set %s: %w", ct.name, field, err))
+				failed = true
+				break
+			}
+			values[field] = value
+		}
+		if failed {
+			continue
+		}
+
+		for field, value := range values {
+			record[field] = value
+			env[field] = value
+		}
+		if ct.topic != "" {
+			topic = ct.topic
+		}
+	}
+	return topic, errors.Join(errs...)
+}
+
+// jsonEnv returns record as plain JSON values, so expressions compare
+// numbers, strings and nulls rather than the Go types records are built from
+func jsonEnv(record map[string]any) (map[string]any, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var env map[string]any
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
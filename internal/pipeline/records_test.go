@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+)
+
+func TestRecordTransformApply(t *testing.T) {
+	tests := []struct {
+		name       string
+		kind       string
+		transforms []config.RecordTransform
+		want       map[string]any
+		wantTopic  string
+		// wantErr is a substring of the expected error, none if empty
+		wantErr string
+	}{
+		{
+			name: "no transforms",
+			kind: config.RecordProcess,
+			want: map[string]any{"state": "COMPLETED", "duration_millis": 7200000},
+		},
+		{
+			name: "set",
+			kind: config.RecordProcess,
+			transforms: []config.RecordTransform{{
+				Set: map[string]string{"sla_breached": "duration_millis > 3600000"},
+			}},
+			want: map[string]any{"state": "COMPLETED", "duration_millis": 7200000, "sla_breached": true},
+		},
+		{
+			name: "other record kind",
+			kind: config.RecordProcess,
+			transforms: []config.RecordTransform{{
+				Records: []string{config.RecordActivity},
+				Set:     map[string]string{"x": "1"},
+			}},
+			want: map[string]any{"state": "COMPLETED", "duration_millis": 7200000},
+		},
+		{
+			name: "when false",
+			kind: config.RecordProcess,
+			transforms: []config.RecordTransform{{
+				When:  `state == "ACTIVE"`,
+				Set:   map[string]string{"x": "1"},
+				Topic: "active",
+			}},
+			want: map[string]any{"state": "COMPLETED", "duration_millis": 7200000},
+		},
+		{
+			name: "later transforms see earlier fields",
+			kind: config.RecordProcess,
+			transforms: []config.RecordTransform{
+				{Set: map[string]string{"slow": "duration_millis > 3600000"}},
+				{When: "slow", Topic: "escalations"},
+			},
+			want:      map[string]any{"state": "COMPLETED", "duration_millis": 7200000, "slow": true},
+			wantTopic: "escalations",
+		},
+		{
+			name: "failed set is skipped",
+			kind: config.RecordProcess,
+			transforms: []config.RecordTransform{
+				{Name: "broken", Set: map[string]string{"x": "1", "y": "missing + 1"}, Topic: "broken"},
+				{Set: map[string]string{"z": "2"}},
+			},
+			want:    map[string]any{"state": "COMPLETED", "duration_millis": 7200000, "z": 2},
+			wantErr: "transform broken: set y",
+		},
+		{
+			name: "when on a null field",
+			kind: config.RecordProcess,
+			transforms: []config.RecordTransform{
+				{Name: "nullable", When: "end_time", Set: map[string]string{"x": "1"}},
+			},
+			want:    map[string]any{"state": "COMPLETED", "duration_millis": 7200000},
+			wantErr: "transform nullable: when",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := NewRecordTransform(tt.transforms)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record := map[string]any{"state": "COMPLETED", "duration_millis": 7200000}
+			topic, err := transform.Apply(tt.kind, record)
+
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Apply() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Apply() error = %v, want %q", err, tt.wantErr)
+			}
+			if topic != tt.wantTopic {
+				t.Errorf("Apply() topic = %q, want %q", topic, tt.wantTopic)
+			}
+			if !reflect.DeepEqual(record, tt.want) {
+				t.Errorf("record = %v, want %v", record, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRecordTransformRejectsReservedFields(t *testing.T) {
+	for _, field := range config.ReservedRecordFields {
+		_, err := NewRecordTransform([]config.RecordTransform{{Set: map[string]string{field: `"x"`}}})
+		if err == nil {
+			t.Errorf("NewRecordTransform() setting %s succeeded", field)
+		}
+	}
+}
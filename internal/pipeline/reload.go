@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// reloadableFields match the configuration fields a running pipeline can
+// change. Anything else (sources, credentials, the sink, Kafka topics, the
+// tokenize key) needs a restart.
+var reloadableFields = regexp.MustCompile(`^(` + strings.Join([]string{
+	`log_level`,
+	`pipeline\.poll_interval`,
+	`pipeline\.adaptive_polling\..*`,
+	`pipeline\.batch_size`,
+	`pipeline\.reconcile\.(interval|policy|retention)`,
+	`pipeline\.variables\.rules(\[\d+\].*)?`,
+	`pipeline\.filters\..*`,
+	`pipeline\.transforms.*`,
+	`pipeline\.sla\..*`,
+	`sources\[\d+\]\.poll_interval`,
+}, "|") + `)$`)
+
+// Reload applies a new configuration to the running pipeline. Poll
+// intervals, adaptive polling, batch size, reconciliation, filters, variable
+// rules, record transforms, SLA rules and the log level take effect
+// immediately; if anything else changed, the whole configuration is rejected
+// and the current one stays in effect.
+func (p *Pipeline) Reload(cfg *config.Config) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	old := p.currentConfig()
+	changed := config.Diff(old, cfg)
+	if len(changed) == 0 {
+		slog.Info("Configuration unchanged")
+		return nil
+	}
+
+	var unsafe []string
+	for _, key := range changed {
+		if !reloadableFields.MatchString(key) {
+			unsafe = append(unsafe, key)
+		}
+	}
+	if len(unsafe) > 0 {
+		return fmt.Errorf("changes to %s require a restart; configuration not reloaded", strings.Join(unsafe, ", "))
+	}
+
+	vars, err := newVariableTransform(cfg.Pipeline.Variables)
+	if err != nil {
+		return err
+	}
+	transforms, err := NewRecordTransform(cfg.Pipeline.Transforms)
+	if err != nil {
+		return err
+	}
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	p.vars.Store(vars)
+	p.transforms.Store(transforms)
+	p.cfg.Store(cfg)
+
+	// Sources can't have changed, so they line up with the running ones
+	for i, sc := range cfg.EffectiveSources() {
+		src := p.sources[i]
+		if src.cfg.Type != config.SourceTypePoll && src.cfg.Type != config.SourceTypeDatabase {
+			continue
+		}
+		// Keep only the latest update if the source hasn't taken the last
+		select {
+		case <-src.updates:
+		default:
+		}
+		src.updates <- sc
+	}
+
+	slog.Info("Configuration reloaded", "changed", changed)
+	return nil
+}
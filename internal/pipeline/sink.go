@@ -0,0 +1,188 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/kafka"
+	"github.com/refset/fluxnova-decision-observability/internal/sink"
+)
+
+// Sink receives the pipeline's records. It is implemented by
+// kafka.Producer and by the sinks in package sink.
+type Sink interface {
+	SendProcess(ctx context.Context, key string, process any) error
+	SendEvent(ctx context.Context, key string, event any) error
+	SendVariables(ctx context.Context, key string, variables any) error
+	SendPurge(ctx context.Context, key string, purge any) error
+	SendBreach(ctx context.Context, key string, breach any) error
+	// SendTo sends a record routed away from its usual topic
+	SendTo(ctx context.Context, topic, key string, record any) error
+	// DeleteProcess removes a process instance's process and variable
+	// records, and the activity records with eventKeys
+	DeleteProcess(ctx context.Context, key string, eventKeys []string) error
+	// Flush returns once the records sent so far are written, so a
+	// checkpoint saved after it never covers records the sink could lose.
+	// It returns sink.ErrPending if the sink is still holding some back,
+	// and an error if any records sent since the last Flush were lost.
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// NewSink creates the sink selected by cfg.Sink, with the webhook endpoints
+// receiving records alongside it if any are configured
+func NewSink(cfg *config.Config) (Sink, error) {
+	topics := sink.Topics{
+		Events:    cfg.Kafka.EventsTopic,
+		Processes: cfg.Kafka.ProcessesTopic,
+		Variables: cfg.Kafka.VariablesTopic,
+		Purges:    cfg.Kafka.PurgesTopic,
+		Breaches:  cfg.Kafka.BreachesTopic,
+	}
+
+	var webhook *sink.Webhook
+	if endpoints := cfg.Sink.Webhook.Endpoints; len(endpoints) > 0 {
+		var err error
+		webhook, err = sink.NewWebhook(webhookEndpoints(endpoints), topics)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Sink.Type == config.SinkTypeWebhook {
+			return webhook, nil
+		}
+	}
+
+	primary, err := newPrimarySink(cfg, topics)
+	if err != nil {
+		return nil, err
+	}
+	if webhook != nil {
+		return multiSink{primary, webhook}, nil
+	}
+	return primary, nil
+}
+
+// newPrimarySink creates the kafka, ndjson or parquet sink
+func newPrimarySink(cfg *config.Config, topics sink.Topics) (Sink, error) {
+	switch cfg.Sink.Type {
+	case config.SinkTypeNDJSON:
+		n := cfg.Sink.NDJSON
+		return sink.NewNDJSON(n.Path, n.MaxSizeMB, n.MaxFiles, topics)
+	case config.SinkTypeParquet:
+		pq := cfg.Sink.Parquet
+		return sink.NewParquet(pq.Dir, pq.FlushInterval, pq.MaxRows, topics)
+	case config.SinkTypeKafka:
+		return kafka.NewProducer(
+			cfg.Kafka.Brokers,
+			cfg.Kafka.EventsTopic,
+			cfg.Kafka.ProcessesTopic,
+			cfg.Kafka.VariablesTopic,
+			cfg.Kafka.PurgesTopic,
+			cfg.Kafka.BreachesTopic,
+		), nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", cfg.Sink.Type)
+}
+
+// webhookEndpoints maps the configured endpoints to the sink's settings
+func webhookEndpoints(cfgs []config.WebhookEndpoint) []sink.WebhookEndpoint {
+	endpoints := make([]sink.WebhookEndpoint, len(cfgs))
+	for i, c := range cfgs {
+		endpoints[i] = sink.WebhookEndpoint{
+			Name:          c.Name,
+			URL:           c.URL,
+			Topics:        c.Topics,
+			When:          c.When,
+			BatchSize:     c.BatchSize,
+			BatchInterval: c.BatchInterval,
+			MaxRetries:    c.MaxRetries,
+			Timeout:       c.Timeout,
+		}
+		if c.Secret != "" {
+			endpoints[i].Secret = c.Secret.Value
+		}
+	}
+	return endpoints
+}
+
+// multiSink sends every record to each of its sinks
+type multiSink []Sink
+
+func (m multiSink) each(fn func(Sink) error) error {
+	var errs []error
+	for _, s := range m {
+		if err := fn(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) SendProcess(ctx context.Context, key string, process any) error {
+	return m.each(func(s Sink) error { return s.SendProcess(ctx, key, process) })
+}
+
+func (m multiSink) SendEvent(ctx context.Context, key string, event any) error {
+	return m.each(func(s Sink) error { return s.SendEvent(ctx, key, event) })
+}
+
+func (m multiSink) SendVariables(ctx context.Context, key string, variables any) error {
+	return m.each(func(s Sink) error { return s.SendVariables(ctx, key, variables) })
+}
+
+func (m multiSink) SendPurge(ctx context.Context, key string, purge any) error {
+	return m.each(func(s Sink) error { return s.SendPurge(ctx, key, purge) })
+}
+
+func (m multiSink) SendBreach(ctx context.Context, key string, breach any) error {
+	return m.each(func(s Sink) error { return s.SendBreach(ctx, key, breach) })
+}
+
+func (m multiSink) SendTo(ctx context.Context, topic, key string, record any) error {
+	return m.each(func(s Sink) error { return s.SendTo(ctx, topic, key, record) })
+}
+
+func (m multiSink) DeleteProcess(ctx context.Context, key string, eventKeys []string) error {
+	return m.each(func(s Sink) error { return s.DeleteProcess(ctx, key, eventKeys) })
+}
+
+// Flush returns sink.ErrPending only if no sink failed, so a failure isn't
+// mistaken for records held back
+func (m multiSink) Flush(ctx context.Context) error {
+	pending := false
+	err := m.each(func(s Sink) error {
+		err := s.Flush(ctx)
+		if errors.Is(err, sink.ErrPending) {
+			pending = true
+			return nil
+		}
+		return err
+	})
+	if err == nil && pending {
+		return sink.ErrPending
+	}
+	return err
+}
+
+func (m multiSink) Close() error {
+	return m.each(func(s Sink) error { return s.Close() })
+}
+
+// sinkDescription describes where a sink configuration writes, for logs
+func sinkDescription(cfg *config.Config) []any {
+	switch cfg.Sink.Type {
+	case config.SinkTypeNDJSON:
+		path := cfg.Sink.NDJSON.Path
+		if path == "" || path == "-" {
+			path = "stdout"
+		}
+		return []any{"sink", cfg.Sink.Type, "path", path}
+	case config.SinkTypeParquet:
+		return []any{"sink", cfg.Sink.Type, "dir", cfg.Sink.Parquet.Dir}
+	case config.SinkTypeWebhook:
+		return []any{"sink", cfg.Sink.Type, "webhooks", len(cfg.Sink.Webhook.Endpoints)}
+	}
+	return []any{"sink", cfg.Sink.Type, "kafka_brokers", cfg.Kafka.Brokers}
+}
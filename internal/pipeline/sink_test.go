@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+)
+
+// sentRecord is a record received by a recordingSink
+type sentRecord struct {
+	kind   string
+	key    string
+	record any
+}
+
+// recordingSink keeps the records sent to it, failing each send while err
+// is set and each flush while flushErr is
+type recordingSink struct {
+	mu       sync.Mutex
+	records  []sentRecord
+	flushes  int
+	err      error
+	flushErr error
+}
+
+func (s *recordingSink) add(kind, key string, record any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, sentRecord{kind: kind, key: key, record: record})
+	return nil
+}
+
+// sent returns the records sent so far
+func (s *recordingSink) sent() []sentRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sentRecord(nil), s.records...)
+}
+
+func (s *recordingSink) SendProcess(ctx context.Context, key string, process any) error {
+	return s.add(config.RecordProcess, key, process)
+}
+
+func (s *recordingSink) SendEvent(ctx context.Context, key string, event any) error {
+	return s.add(config.RecordActivity, key, event)
+}
+
+func (s *recordingSink) SendVariables(ctx context.Context, key string, variables any) error {
+	return s.add(config.RecordVariables, key, variables)
+}
+
+func (s *recordingSink) SendPurge(ctx context.Context, key string, purge any) error {
+	return s.add("purge", key, purge)
+}
+
+func (s *recordingSink) SendBreach(ctx context.Context, key string, breach any) error {
+	return s.add("breach", key, breach)
+}
+
+func (s *recordingSink) SendTo(ctx context.Context, topic, key string, record any) error {
+	return s.add(topic, key, record)
+}
+
+func (s *recordingSink) DeleteProcess(ctx context.Context, key string, eventKeys []string) error {
+	return s.add("delete", key, eventKeys)
+}
+
+func (s *recordingSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushes++
+	return s.flushErr
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+// newTestPipeline returns a pipeline with one source configured by src that
+// sends to a recordingSink
+func newTestPipeline(t *testing.T, cfg *config.Config, src config.SourceConfig) (*Pipeline, *source, *recordingSink) {
+	t.Helper()
+	if src.ID == "" {
+		src.ID = "default"
+	}
+	if src.BaseURL == "" {
+		src.BaseURL = "http://engine.invalid/engine-rest"
+	}
+	s, err := newSource(src, cfg.Pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := newVariableTransform(cfg.Pipeline.Variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transforms, err := NewRecordTransform(cfg.Pipeline.Transforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &recordingSink{}
+	p := &Pipeline{sources: []*source{s}, sink: sink}
+	p.cfg.Store(cfg)
+	p.vars.Store(vars)
+	p.transforms.Store(transforms)
+	return p, s, sink
+}
@@ -0,0 +1,401 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// Breach types
+const (
+	breachSLA   = "sla"
+	breachStuck = "stuck"
+)
+
+// slaState is what a source's SLA checks remember between runs, so an open
+// breach is published once rather than on every check
+type slaState struct {
+	// Ids of the open SLA breaches already published
+	reported map[string]bool
+	// Last progress of the stuck instances already published, by process
+	// instance id
+	stuck map[string]time.Time
+}
+
+func newSLAState() slaState {
+	return slaState{reported: make(map[string]bool), stuck: make(map[string]time.Time)}
+}
+
+// observeSLAs publishes the breaches of SLA rules by an event's finished
+// process instance and activities. Items still running are left to
+// checkSLAs, which asks the engine.
+func (p *Pipeline) observeSLAs(ctx context.Context, src *source, event fluxnova.ProcessEvent) {
+	logger := logging.FromContext(ctx).With("process_instance_id", event.ProcessInstanceID)
+
+	for _, rule := range p.currentConfig().Pipeline.SLA.Rules {
+		if !matchPattern(rule.ProcessDefinition, event.ProcessDefinition) {
+			continue
+		}
+		if rule.Activity == "" {
+			if event.EventType != fluxnova.EventTypeProcessInstance || event.EndTime == nil {
+				continue
+			}
+			if event.EndTime.Sub(event.StartTime.Time) <= rule.MaxDuration {
+				continue
+			}
+			record := slaBreachRecord(rule, event.ProcessInstanceID, event.ProcessDefinition, nil, event.StartTime, event.EndTime)
+			if err := p.publishBreach(ctx, src, record, event.TenantID); err != nil {
+				logger.Error("Failed to send SLA breach", "rule", rule.Name, logging.Error(err))
+			}
+			continue
+		}
+
+		for _, act := range event.Activities {
+			if act.EndTime == nil || !matchPattern(rule.Activity, act.ActivityID) {
+				continue
+			}
+			if act.EndTime.Sub(act.StartTime.Time) <= rule.MaxDuration {
+				continue
+			}
+			record := slaBreachRecord(rule, event.ProcessInstanceID, event.ProcessDefinition, &act, act.StartTime, act.EndTime)
+			if err := p.publishBreach(ctx, src, record, act.TenantID); err != nil {
+				logger.Error("Failed to send SLA breach", "rule", rule.Name, "activity_instance_id", act.ID, logging.Error(err))
+			}
+		}
+	}
+}
+
+// runSLAChecks checks a pushed source's SLAs on the source's poll interval,
+// as a polling source does after each poll, until the context is cancelled.
+// It only touches the source's SLA state, so it runs alongside the source's
+// own goroutine.
+func (p *Pipeline) runSLAChecks(ctx context.Context, src *source) {
+	ticker := time.NewTicker(src.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.checkSLAs(ctx, src); err != nil {
+				src.logger.Error("SLA check failed", logging.Error(err))
+			}
+		}
+	}
+}
+
+// checkSLAs asks the engine for running process instances and activities
+// that have exceeded an SLA rule's duration, and for running instances that
+// made no progress for the stuck_after duration, and publishes those not
+// published yet. It needs the source's REST API and does nothing without
+// one.
+func (p *Pipeline) checkSLAs(ctx context.Context, src *source) error {
+	pcfg := p.currentConfig().Pipeline
+	if src.cfg.BaseURL == "" || (len(pcfg.SLA.Rules) == 0 && pcfg.SLA.StuckAfter == 0) {
+		return nil
+	}
+	now := time.Now()
+
+	var errs []error
+	reported := make(map[string]bool)
+	for _, rule := range pcfg.SLA.Rules {
+		if err := p.checkRule(ctx, src, rule, now, reported); err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.Name, err))
+			// Keep what was reported for the rule, so it isn't repeated
+			// when the next check succeeds
+			for id := range src.sla.reported {
+				if strings.HasPrefix(id, rule.Name+"/") {
+					reported[id] = true
+				}
+			}
+		}
+	}
+	// Breaches no longer open are forgotten; they can't be found again
+	src.sla.reported = reported
+
+	if pcfg.SLA.StuckAfter > 0 {
+		if err := p.checkStuck(ctx, src, pcfg.SLA.StuckAfter, now); err != nil {
+			errs = append(errs, fmt.Errorf("stuck instances: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkRule publishes the running items breaching rule, adding the ids of
+// the open breaches to reported
+func (p *Pipeline) checkRule(ctx context.Context, src *source, rule config.SLARule, now time.Time, reported map[string]bool) error {
+	batchSize := p.currentConfig().Pipeline.BatchSize
+	deadline := now.Add(-rule.MaxDuration)
+	logger := logging.FromContext(ctx)
+
+	var errs []error
+	publish := func(id string, record map[string]any, tenantID *string) {
+		if src.sla.reported[id] {
+			reported[id] = true
+			return
+		}
+		if err := p.publishBreach(ctx, src, record, tenantID); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		logger.Info("SLA breached", "rule", rule.Name, "process_instance_id", record["process_instance_id"])
+		reported[id] = true
+	}
+
+	if rule.Activity == "" {
+		q := fluxnova.ProcessInstanceQuery{
+			StartedBefore:   &deadline,
+			Unfinished:      true,
+			TenantIDs:       src.cfg.TenantIDs,
+			WithoutTenantID: src.cfg.WithoutTenantID,
+			MaxResults:      batchSize,
+		}
+		if rule.ProcessDefinition != "" && plainPattern(rule.ProcessDefinition) {
+			q.ProcessDefinitionKeys = []string{rule.ProcessDefinition}
+		}
+		for {
+			procs, err := src.client.GetHistoricProcessInstances(q)
+			if err != nil {
+				return err
+			}
+			for _, proc := range procs {
+				if !matchPattern(rule.ProcessDefinition, proc.ProcessDefinitionKey) ||
+					!p.matchSLAFilters(proc.ProcessDefinitionKey, proc.ProcessDefinitionID, proc.TenantID) {
+					continue
+				}
+				record := slaBreachRecord(rule, proc.ID, proc.ProcessDefinitionKey, nil, proc.StartTime, nil)
+				publish(record["_id"].(string), record, proc.TenantID)
+			}
+			if len(procs) < batchSize {
+				break
+			}
+			q.FirstResult += len(procs)
+		}
+		return errors.Join(errs...)
+	}
+
+	q := fluxnova.ActivityInstanceQuery{
+		StartedBefore:   &deadline,
+		Unfinished:      true,
+		TenantIDs:       src.cfg.TenantIDs,
+		WithoutTenantID: src.cfg.WithoutTenantID,
+		MaxResults:      batchSize,
+	}
+	if plainPattern(rule.Activity) {
+		q.ActivityID = rule.Activity
+	}
+	for {
+		acts, err := src.client.FindHistoricActivityInstances(q)
+		if err != nil {
+			return err
+		}
+		for _, act := range acts {
+			if !matchPattern(rule.Activity, act.ActivityID) ||
+				!matchPattern(rule.ProcessDefinition, act.ProcessDefinitionKey) ||
+				!p.matchSLAFilters(act.ProcessDefinitionKey, act.ProcessDefinitionID, act.TenantID) {
+				continue
+			}
+			record := slaBreachRecord(rule, act.ProcessInstanceID, act.ProcessDefinitionKey, &act, act.StartTime, nil)
+			publish(record["_id"].(string), record, act.TenantID)
+		}
+		if len(acts) < batchSize {
+			break
+		}
+		q.FirstResult += len(acts)
+	}
+	return errors.Join(errs...)
+}
+
+// stuckCandidate is a running process instance with an activity open since
+// before the stuck_after cutoff
+type stuckCandidate struct {
+	definitionKey string
+	tenantID      *string
+	// latestOpen is the start of the latest such activity
+	latestOpen time.Time
+}
+
+// checkStuck publishes the running instances in which no activity started
+// or ended for stuckAfter. Instances waiting in a call activity are left
+// out, since the called instance is checked itself. Each stall of an
+// instance is published once.
+func (p *Pipeline) checkStuck(ctx context.Context, src *source, stuckAfter time.Duration, now time.Time) error {
+	batchSize := p.currentConfig().Pipeline.BatchSize
+	cutoff := now.Add(-stuckAfter)
+
+	// Only instances with an activity open since before the cutoff can be
+	// stuck
+	candidates := make(map[string]*stuckCandidate)
+	q := fluxnova.ActivityInstanceQuery{
+		StartedBefore:   &cutoff,
+		Unfinished:      true,
+		TenantIDs:       src.cfg.TenantIDs,
+		WithoutTenantID: src.cfg.WithoutTenantID,
+		MaxResults:      batchSize,
+	}
+	for {
+		acts, err := src.client.FindHistoricActivityInstances(q)
+		if err != nil {
+			return err
+		}
+		for _, act := range acts {
+			if act.ActivityType == "callActivity" ||
+				!p.matchSLAFilters(act.ProcessDefinitionKey, act.ProcessDefinitionID, act.TenantID) {
+				continue
+			}
+			c, ok := candidates[act.ProcessInstanceID]
+			if !ok {
+				c = &stuckCandidate{definitionKey: act.ProcessDefinitionKey, tenantID: act.TenantID}
+				candidates[act.ProcessInstanceID] = c
+			}
+			if act.StartTime.After(c.latestOpen) {
+				c.latestOpen = act.StartTime.Time
+			}
+		}
+		if len(acts) < batchSize {
+			break
+		}
+		q.FirstResult += len(acts)
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	logger := logging.FromContext(ctx)
+	stuck := make(map[string]time.Time)
+	var errs []error
+	for _, id := range ids {
+		c := candidates[id]
+		if last, ok := src.sla.stuck[id]; ok && !c.latestOpen.After(last) {
+			// Already published, and nothing has started since
+			stuck[id] = last
+			continue
+		}
+
+		acts, err := src.client.GetHistoricActivityInstances(id)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var lastProgress time.Time
+		var open []string
+		for _, act := range acts {
+			if act.StartTime.After(lastProgress) {
+				lastProgress = act.StartTime.Time
+			}
+			if act.EndTime == nil {
+				open = append(open, act.ActivityID)
+			} else if act.EndTime.After(lastProgress) {
+				lastProgress = act.EndTime.Time
+			}
+		}
+		if len(open) == 0 || lastProgress.After(cutoff) {
+			continue
+		}
+		if last, ok := src.sla.stuck[id]; ok && last.Equal(lastProgress) {
+			stuck[id] = last
+			continue
+		}
+
+		record := map[string]any{
+			"_id":                    breachStuck + "/" + id,
+			"breach_type":            breachStuck,
+			"rule":                   nil,
+			"process_instance_id":    id,
+			"process_definition_key": c.definitionKey,
+			"activity_instance_id":   nil,
+			"activity_id":            nil,
+			"open_activity_ids":      open,
+			"last_progress_at":       fluxnova.NewTime(lastProgress),
+			"max_duration_millis":    stuckAfter.Milliseconds(),
+			"breached_at":            fluxnova.NewTime(lastProgress.Add(stuckAfter)),
+			"detected_at":            fluxnova.NewTime(now),
+			"_valid_from":            fluxnova.NewTime(lastProgress.Add(stuckAfter)),
+		}
+		if err := p.publishBreach(ctx, src, record, c.tenantID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logger.Info("Process instance stuck", "process_instance_id", id, "last_progress_at", record["last_progress_at"])
+		stuck[id] = lastProgress
+	}
+
+	if len(errs) > 0 {
+		// Keep the earlier stalls so they aren't published again
+		for id, last := range src.sla.stuck {
+			if _, ok := stuck[id]; !ok {
+				stuck[id] = last
+			}
+		}
+	}
+	src.sla.stuck = stuck
+	return errors.Join(errs...)
+}
+
+// slaBreachRecord builds the breach of rule by a process instance, or by
+// its activity act if act is set. end is nil if the item is still running.
+func slaBreachRecord(rule config.SLARule, processInstanceID, definitionKey string, act *fluxnova.HistoricActivityInstance, start fluxnova.Time, end *fluxnova.Time) map[string]any {
+	itemID := processInstanceID
+	var activityInstanceID, activityID *string
+	if act != nil {
+		itemID = act.ID
+		activityInstanceID, activityID = &act.ID, &act.ActivityID
+	}
+	breachedAt := fluxnova.NewTime(start.Add(rule.MaxDuration))
+
+	return map[string]any{
+		"_id":                    rule.Name + "/" + itemID,
+		"breach_type":            breachSLA,
+		"rule":                   rule.Name,
+		"process_instance_id":    processInstanceID,
+		"process_definition_key": definitionKey,
+		"activity_instance_id":   activityInstanceID,
+		"activity_id":            activityID,
+		"started_at":             start,
+		"ended_at":               end,
+		"max_duration_millis":    rule.MaxDuration.Milliseconds(),
+		"breached_at":            breachedAt,
+		"detected_at":            fluxnova.NewTime(time.Now()),
+		"_valid_from":            breachedAt,
+	}
+}
+
+// publishBreach sends a breach record to the breaches topic. Its valid time
+// is when the breach happened, which may be before it was detected.
+func (p *Pipeline) publishBreach(ctx context.Context, src *source, record map[string]any, tenantID *string) error {
+	key := src.recordID(record["_id"].(string))
+	record["_id"] = key
+	src.stamp(record, tenantID)
+	return p.sink.SendBreach(ctx, key, record)
+}
+
+// matchSLAFilters applies the pipeline's process definition and tenant
+// filters to items found by the SLA checks, which the checks' queries can't
+// express. Business keys aren't known for activities and aren't filtered.
+func (p *Pipeline) matchSLAFilters(definitionKey, definitionID string, tenantID *string) bool {
+	filters := p.currentConfig().Pipeline.Filters
+	return matchDefinition(filters.ProcessDefinitions, definitionKey, fluxnova.DefinitionVersionFromID(definitionID)) &&
+		matchFilter(filters.Tenants, deref(tenantID))
+}
+
+// matchPattern reports whether value matches a glob; an empty pattern
+// matches everything
+func matchPattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
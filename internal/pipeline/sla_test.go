@@ -0,0 +1,332 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+)
+
+// slaEngine is a fake engine REST API answering the SLA checks' queries
+// for running process instances and activities
+type slaEngine struct {
+	mu         sync.Mutex
+	processes  []fluxnova.HistoricProcessInstance
+	activities []fluxnova.HistoricActivityInstance
+}
+
+// newSLAEngine starts a fake engine and returns it with its REST API URL
+func newSLAEngine(t *testing.T) (*slaEngine, string) {
+	t.Helper()
+	e := &slaEngine{}
+	server := httptest.NewServer(http.HandlerFunc(e.serve))
+	t.Cleanup(server.Close)
+	return e, server.URL + "/engine-rest"
+}
+
+// set replaces the engine's history
+func (e *slaEngine) set(processes []fluxnova.HistoricProcessInstance, activities []fluxnova.HistoricActivityInstance) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.processes, e.activities = processes, activities
+}
+
+func (e *slaEngine) serve(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q := r.URL.Query()
+
+	// Every answer fits in the first page
+	var result any = []struct{}{}
+	if q.Get("firstResult") == "" {
+		var before time.Time
+		if s := q.Get("startedBefore"); s != "" {
+			t, err := fluxnova.ParseTime(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			before = t.Time
+		}
+		started := func(t fluxnova.Time) bool { return before.IsZero() || t.Before(before) }
+
+		switch r.URL.Path {
+		case "/engine-rest/history/process-instance":
+			var procs []fluxnova.HistoricProcessInstance
+			for _, proc := range e.processes {
+				if proc.EndTime == nil && started(proc.StartTime) {
+					procs = append(procs, proc)
+				}
+			}
+			result = procs
+		case "/engine-rest/history/activity-instance":
+			var acts []fluxnova.HistoricActivityInstance
+			for _, act := range e.activities {
+				if id := q.Get("processInstanceId"); id != "" {
+					if act.ProcessInstanceID == id {
+						acts = append(acts, act)
+					}
+					continue
+				}
+				if act.EndTime == nil && started(act.StartTime) &&
+					(q.Get("activityId") == "" || act.ActivityID == q.Get("activityId")) {
+					acts = append(acts, act)
+				}
+			}
+			result = acts
+		default:
+			http.NotFound(w, r)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// slaHistory is the running history an SLA check sees
+type slaHistory struct {
+	processes  []fluxnova.HistoricProcessInstance
+	activities []fluxnova.HistoricActivityInstance
+}
+
+// slaCheck is one SLA check of a test: the engine's history at the time,
+// and the ids of the breaches it should publish
+type slaCheck struct {
+	history slaHistory
+	want    []string
+}
+
+// checkSLAsInTurn runs checks in turn against a pipeline configured by pcfg,
+// comparing the breaches each publishes with its want
+func checkSLAsInTurn(t *testing.T, pcfg config.PipelineConfig, checks []slaCheck) []map[string]any {
+	t.Helper()
+	engine, url := newSLAEngine(t)
+	pcfg.BatchSize = 100
+	p, src, rec := newTestPipeline(t, &config.Config{Pipeline: pcfg}, config.SourceConfig{
+		FluxnovaConfig: config.FluxnovaConfig{BaseURL: url},
+	})
+
+	var breaches []map[string]any
+	for i, check := range checks {
+		engine.set(check.history.processes, check.history.activities)
+		before := len(rec.sent())
+		if err := p.checkSLAs(context.Background(), src); err != nil {
+			t.Fatalf("check %d: %v", i+1, err)
+		}
+
+		var got []string
+		for _, sent := range rec.sent()[before:] {
+			breach := sent.record.(map[string]any)
+			breaches = append(breaches, breach)
+			got = append(got, sent.key)
+		}
+		if !reflect.DeepEqual(got, check.want) {
+			t.Errorf("check %d published %v, want %v", i+1, got, check.want)
+		}
+	}
+	return breaches
+}
+
+func TestCheckSLARules(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) fluxnova.Time { return fluxnova.NewTime(now.Add(-d)) }
+	ended := ago(time.Minute)
+
+	process := func(id, definition string, started time.Duration) fluxnova.HistoricProcessInstance {
+		return fluxnova.HistoricProcessInstance{
+			ID: id, ProcessDefinitionKey: definition, ProcessDefinitionID: definition + ":1:x", StartTime: ago(started),
+		}
+	}
+	activity := func(id, activityID string, started time.Duration) fluxnova.HistoricActivityInstance {
+		return fluxnova.HistoricActivityInstance{
+			ID: id, ActivityID: activityID, ActivityType: "userTask", ProcessInstanceID: "pi-1",
+			ProcessDefinitionKey: "order", ProcessDefinitionID: "order:1:x", StartTime: ago(started),
+		}
+	}
+	finishedActivity := func(id, activityID string, started time.Duration) fluxnova.HistoricActivityInstance {
+		act := activity(id, activityID, started)
+		act.EndTime = &ended
+		return act
+	}
+
+	orderRule := config.SLARule{Name: "order-hour", ProcessDefinition: "order", MaxDuration: time.Hour}
+	reviewRule := config.SLARule{Name: "review", Activity: "Review*", MaxDuration: time.Hour}
+
+	tests := []struct {
+		name    string
+		rules   []config.SLARule
+		filters config.FiltersConfig
+		checks  []slaCheck
+	}{
+		{
+			name:  "running instance past its max duration",
+			rules: []config.SLARule{orderRule},
+			checks: []slaCheck{{
+				history: slaHistory{processes: []fluxnova.HistoricProcessInstance{
+					process("pi-late", "order", 2*time.Hour),
+					process("pi-on-time", "order", 10*time.Minute),
+					process("pi-other", "invoice", 2*time.Hour),
+				}},
+				want: []string{"default/order-hour/pi-late"},
+			}},
+		},
+		{
+			name:  "running activity matching the rule's pattern",
+			rules: []config.SLARule{reviewRule},
+			checks: []slaCheck{{
+				history: slaHistory{activities: []fluxnova.HistoricActivityInstance{
+					activity("act-late", "ReviewOrder", 2*time.Hour),
+					activity("act-on-time", "ReviewOrder", 10*time.Minute),
+					activity("act-other", "ShipOrder", 2*time.Hour),
+				}},
+				want: []string{"default/review/act-late"},
+			}},
+		},
+		{
+			name:  "open breach is published once",
+			rules: []config.SLARule{orderRule},
+			checks: []slaCheck{
+				{
+					history: slaHistory{processes: []fluxnova.HistoricProcessInstance{process("pi-late", "order", 2*time.Hour)}},
+					want:    []string{"default/order-hour/pi-late"},
+				},
+				{
+					history: slaHistory{processes: []fluxnova.HistoricProcessInstance{process("pi-late", "order", 2*time.Hour)}},
+				},
+			},
+		},
+		{
+			name:  "breach is published again after it was resolved",
+			rules: []config.SLARule{reviewRule},
+			checks: []slaCheck{
+				{
+					history: slaHistory{activities: []fluxnova.HistoricActivityInstance{activity("act-1", "Review", 2*time.Hour)}},
+					want:    []string{"default/review/act-1"},
+				},
+				{
+					// The activity finished, e.g. before a migration restored it
+					history: slaHistory{activities: []fluxnova.HistoricActivityInstance{finishedActivity("act-1", "Review", 2*time.Hour)}},
+				},
+				{
+					history: slaHistory{activities: []fluxnova.HistoricActivityInstance{activity("act-1", "Review", 2*time.Hour)}},
+					want:    []string{"default/review/act-1"},
+				},
+			},
+		},
+		{
+			name:    "pipeline filters apply",
+			rules:   []config.SLARule{{Name: "any-hour", MaxDuration: time.Hour}},
+			filters: config.FiltersConfig{ProcessDefinitions: config.Filter{Exclude: []string{"invoice"}}},
+			checks: []slaCheck{{
+				history: slaHistory{processes: []fluxnova.HistoricProcessInstance{
+					process("pi-order", "order", 2*time.Hour),
+					process("pi-invoice", "invoice", 2*time.Hour),
+				}},
+				want: []string{"default/any-hour/pi-order"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pcfg := config.PipelineConfig{Filters: tt.filters}
+			pcfg.SLA.Rules = tt.rules
+			checkSLAsInTurn(t, pcfg, tt.checks)
+		})
+	}
+}
+
+func TestCheckStuck(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) fluxnova.Time { return fluxnova.NewTime(now.Add(-d)) }
+
+	// activity is an activity of pi-1 started started ago, and ended ended
+	// ago unless ended is 0
+	activity := func(id, activityType string, started, ended time.Duration) fluxnova.HistoricActivityInstance {
+		act := fluxnova.HistoricActivityInstance{
+			ID: id, ActivityID: id, ActivityType: activityType, ProcessInstanceID: "pi-1",
+			ProcessDefinitionKey: "order", ProcessDefinitionID: "order:1:x", StartTime: ago(started),
+		}
+		if ended > 0 {
+			end := ago(ended)
+			act.EndTime = &end
+		}
+		return act
+	}
+	history := func(acts ...fluxnova.HistoricActivityInstance) slaHistory {
+		return slaHistory{activities: acts}
+	}
+	stalled := history(
+		activity("start", "startEvent", 3*time.Hour, 3*time.Hour),
+		activity("approve", "userTask", 3*time.Hour, 0),
+	)
+
+	tests := []struct {
+		name   string
+		checks []slaCheck
+	}{
+		{
+			name:   "no progress for stuck_after",
+			checks: []slaCheck{{history: stalled, want: []string{"default/stuck/pi-1"}}},
+		},
+		{
+			name: "recent progress in a parallel branch",
+			checks: []slaCheck{{history: history(
+				activity("approve", "userTask", 3*time.Hour, 0),
+				activity("notify", "serviceTask", 2*time.Hour, 10*time.Minute),
+			)}},
+		},
+		{
+			name: "waiting in a call activity",
+			checks: []slaCheck{{history: history(
+				activity("start", "startEvent", 3*time.Hour, 3*time.Hour),
+				activity("subprocess", "callActivity", 3*time.Hour, 0),
+			)}},
+		},
+		{
+			name: "a stall is published once",
+			checks: []slaCheck{
+				{history: stalled, want: []string{"default/stuck/pi-1"}},
+				{history: stalled},
+			},
+		},
+		{
+			name: "a new stall after progress is published again",
+			checks: []slaCheck{
+				{history: stalled, want: []string{"default/stuck/pi-1"}},
+				{history: history(
+					activity("start", "startEvent", 3*time.Hour, 3*time.Hour),
+					activity("approve", "userTask", 3*time.Hour, 2*time.Hour),
+					activity("ship", "userTask", 2*time.Hour, 0),
+				), want: []string{"default/stuck/pi-1"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pcfg := config.PipelineConfig{}
+			pcfg.SLA.StuckAfter = time.Hour
+			breaches := checkSLAsInTurn(t, pcfg, tt.checks)
+
+			for _, breach := range breaches {
+				if breach["breach_type"] != breachStuck {
+					t.Errorf("breach_type = %v, want %s", breach["breach_type"], breachStuck)
+				}
+			}
+			if len(breaches) == 2 {
+				first, second := breaches[0]["last_progress_at"].(fluxnova.Time), breaches[1]["last_progress_at"].(fluxnova.Time)
+				if !second.After(first.Time) {
+					t.Errorf("second stall's last progress %v isn't after the first's %v", second, first)
+				}
+			}
+		})
+	}
+}
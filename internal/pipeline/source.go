@@ -0,0 +1,284 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+	"github.com/refset/fluxnova-decision-observability/internal/sink"
+)
+
+// historyPoller reads new process history from an engine. It is
+// implemented by fluxnova.Poller (REST API) and fluxnova.DBPoller (engine
+// database).
+type historyPoller interface {
+	Poll(ctx context.Context) ([]fluxnova.ProcessEvent, error)
+	SetCheckpoint(t *time.Time)
+	GetCheckpoint() *time.Time
+	SetBatchSize(n int)
+	SetProcessDefinitionFilter(keys, excluded []string)
+}
+
+// source is one Fluxnova engine with its own checkpoint and variable
+// snapshot state. Each source is captured by a single goroutine.
+type source struct {
+	cfg    config.SourceConfig
+	client *fluxnova.Client
+	poller historyPoller
+	db     *fluxnova.DBPoller
+
+	// Last published variable snapshot per process instance, dropped when
+	// the instance ends
+	snapshots *instanceCache[variableSnapshot]
+
+	// Process instances published from this source, checked for deletion
+	// in the engine during reconciliation. Pushed sources aren't reconciled
+	// and leave it empty.
+	known *instanceCache[*knownInstance]
+
+	// Current variables of running instances, accumulated from pushed
+	// variable events so each snapshot is complete, dropped when the
+	// instance ends
+	liveVariables *instanceCache[map[string]any]
+
+	// Process instances filtered out when their start was pushed, whose
+	// later events are dropped too
+	excluded *instanceCache[struct{}]
+
+	// Open SLA breaches and stuck instances already published
+	sla slaState
+
+	// unsaved is set while the source's checkpoint is behind its poller
+	// because the sink hadn't written the records polled since
+	unsaved bool
+
+	// Reloaded settings for a polling source, applied by its goroutine
+	updates chan config.SourceConfig
+
+	// logger adds the source id as engine to every record
+	logger *slog.Logger
+}
+
+// pollPosition is where a source's poller reads from next
+type pollPosition struct {
+	checkpoint *time.Time
+	id         string
+}
+
+// position returns where the source's poller reads from next
+func (s *source) position() pollPosition {
+	pos := pollPosition{checkpoint: s.poller.GetCheckpoint()}
+	if s.db != nil {
+		pos.id = s.db.GetCheckpointID()
+	}
+	return pos
+}
+
+// rewind moves the source's poller back to pos, so events are polled
+// again, and forgets their variable snapshots so they are published again
+// in full
+func (s *source) rewind(pos pollPosition, events []fluxnova.ProcessEvent) {
+	s.poller.SetCheckpoint(pos.checkpoint)
+	if s.db != nil {
+		s.db.SetCheckpointID(pos.id)
+	}
+	for _, event := range events {
+		s.snapshots.Delete(event.ProcessInstanceID)
+	}
+}
+
+// variableSnapshot identifies a published version of a process's variables
+type variableSnapshot struct {
+	hash string
+	asOf time.Time
+}
+
+// newSource creates the client and poller for a source and restores its
+// checkpoint if one was saved
+func newSource(cfg config.SourceConfig, pcfg config.PipelineConfig) (*source, error) {
+	client, err := fluxnova.NewClientFromConfig(cfg.FluxnovaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %w", cfg.ID, err)
+	}
+
+	src := &source{
+		cfg:       cfg,
+		client:    client,
+		snapshots: newInstanceCache[variableSnapshot](maxTrackedInstances),
+		known:     newInstanceCache[*knownInstance](maxTrackedInstances),
+
+		liveVariables: newInstanceCache[map[string]any](maxTrackedInstances),
+		excluded:      newInstanceCache[struct{}](maxTrackedInstances),
+		sla:           newSLAState(),
+		updates:       make(chan config.SourceConfig, 1),
+		logger:        slog.With("engine", cfg.ID),
+	}
+
+	if cfg.Type == config.SourceTypeDatabase {
+		db, err := fluxnova.NewDBPoller(context.Background(), cfg.DatabaseURL.Value, pcfg.BatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", cfg.ID, err)
+		}
+		db.SetFetchBinaryVariables(pcfg.FetchBinaryVariables)
+		db.SetTenantFilter(cfg.TenantIDs, cfg.WithoutTenantID)
+		loc, err := time.LoadLocation(cfg.EngineTimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", cfg.ID, err)
+		}
+		db.SetTimeZone(loc)
+		src.db = db
+		src.poller = db
+	} else {
+		poller := fluxnova.NewPoller(client, pcfg.BatchSize)
+		poller.SetFetchBinaryVariables(pcfg.FetchBinaryVariables)
+		poller.SetTenantFilter(cfg.TenantIDs, cfg.WithoutTenantID)
+		src.poller = poller
+	}
+	src.applyPipelineConfig(pcfg)
+
+	if cfg.CheckpointFile != "" {
+		cp, err := loadCheckpoint(cfg.CheckpointFile)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: load checkpoint: %w", cfg.ID, err)
+		}
+		if cp != nil {
+			if cp.LastPollTime != nil {
+				src.poller.SetCheckpoint(cp.LastPollTime)
+				if src.db != nil {
+					src.db.SetCheckpointID(cp.LastID)
+				}
+				src.logger.Info("Resuming from checkpoint", "checkpoint", *cp.LastPollTime)
+			}
+			for id, known := range cp.Known {
+				src.known.Set(id, known)
+			}
+			for _, id := range cp.KnownInstances {
+				src.track(id)
+			}
+		}
+	}
+
+	return src, nil
+}
+
+// applyPipelineConfig passes the pipeline settings a poller applies itself
+// to the source's poller
+func (s *source) applyPipelineConfig(pcfg config.PipelineConfig) {
+	s.poller.SetBatchSize(pcfg.BatchSize)
+	s.poller.SetProcessDefinitionFilter(definitionKeyFilter(pcfg.Filters.ProcessDefinitions))
+}
+
+// pushed reports whether the engine pushes history events to the source
+func (s *source) pushed() bool {
+	return s.cfg.Type == config.SourceTypeWebhook || s.cfg.Type == config.SourceTypeKafka
+}
+
+// ping checks connectivity to the engine (or its database) for sources that
+// read from it; pushed-event sources have nothing to check
+func (s *source) ping(ctx context.Context) error {
+	switch s.cfg.Type {
+	case config.SourceTypePoll:
+		return s.client.Ping()
+	case config.SourceTypeDatabase:
+		return s.db.Ping(ctx)
+	}
+	return nil
+}
+
+// close releases the source's connections
+func (s *source) close() {
+	if s.db != nil {
+		s.db.Close()
+	}
+}
+
+// batchContext returns a context whose logger tags records with the source
+// and a new batch id, so the log lines of one poll or pushed batch can be
+// correlated
+func (s *source) batchContext(ctx context.Context) context.Context {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return logging.WithLogger(ctx, s.logger.With("batch_id", hex.EncodeToString(id)))
+}
+
+// saveCheckpoint persists the poller's checkpoint and known instances if the
+// source has a checkpoint file. Callers flush the sink first, see
+// Pipeline.saveCheckpoint.
+func (s *source) saveCheckpoint() {
+	if s.cfg.CheckpointFile == "" {
+		return
+	}
+
+	cp := checkpoint{LastPollTime: s.poller.GetCheckpoint(), Known: s.knownInstances()}
+	if s.db != nil {
+		cp.LastID = s.db.GetCheckpointID()
+	}
+
+	if err := saveCheckpoint(s.cfg.CheckpointFile, cp); err != nil {
+		s.logger.Error("Failed to save checkpoint", logging.Error(err))
+	}
+}
+
+// saveCheckpoint flushes the sink and then saves a source's checkpoint, so
+// the checkpoint only advances past records the sink has written. While the
+// sink holds records back (sink.ErrPending) the save is left for a later
+// poll. If the flush fails the checkpoint is left as it was and the error
+// returned, so the caller can poll those records again.
+func (p *Pipeline) saveCheckpoint(ctx context.Context, src *source) error {
+	if src.cfg.CheckpointFile == "" {
+		return nil
+	}
+	err := p.sink.Flush(ctx)
+	if errors.Is(err, sink.ErrPending) {
+		logging.FromContext(ctx).Debug("Sink is holding records back, checkpoint not saved yet")
+		src.unsaved = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("flush sink, checkpoint not saved: %w", err)
+	}
+	src.saveCheckpoint()
+	src.unsaved = false
+	return nil
+}
+
+// mergeLiveVariables applies a pushed variable event to the instance's
+// accumulated variables and returns a copy of the result
+func (s *source) mergeLiveVariables(event fluxnova.ProcessEvent) map[string]any {
+	vars, ok := s.liveVariables.Get(event.ProcessInstanceID)
+	if !ok {
+		vars = make(map[string]any)
+		s.liveVariables.Set(event.ProcessInstanceID, vars)
+	}
+	for name, value := range event.Variables {
+		vars[name] = value
+	}
+	for _, name := range event.DeletedVariables {
+		delete(vars, name)
+	}
+
+	merged := make(map[string]any, len(vars))
+	for name, value := range vars {
+		merged[name] = value
+	}
+	return merged
+}
+
+// recordID namespaces an engine id, such as a process instance id, by the
+// source so that records of different engines never share an _id or key
+func (s *source) recordID(id string) string {
+	return s.cfg.ID + "/" + id
+}
+
+// stamp adds the source's engine id and the record's tenant to a record
+func (s *source) stamp(record map[string]any, tenantID *string) {
+	record["engine_id"] = s.cfg.ID
+	record["tenant_id"] = tenantID
+}
@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/kafka"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// maxWebhookBody is the largest request body a webhook source accepts
+const maxWebhookBody = 10 << 20
+
+// runWebhookSource serves an HTTP endpoint that an engine-side history event
+// handler POSTs events to, until the context is cancelled. Events are handed
+// to this goroutine over a channel so the source's state stays
+// single-threaded, and a request is only answered once its events are
+// published, so the engine retries a batch the sink didn't take.
+func (p *Pipeline) runWebhookSource(ctx context.Context, src *source) error {
+	// Each request is a batch, logged with its own batch id. The outcome of
+	// publishing it is sent on done.
+	type batch struct {
+		ctx    context.Context
+		events []fluxnova.ProcessEvent
+		done   chan error
+	}
+	batches := make(chan batch)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(src.cfg.Stream.Path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Resolved per request so a rotated token takes effect immediately
+		token, err := src.cfg.Stream.Token.Value()
+		if err != nil {
+			src.logger.Error("Failed to resolve webhook token", logging.Error(err))
+			http.Error(w, "token unavailable", http.StatusInternalServerError)
+			return
+		}
+		if token == "" && !src.cfg.Stream.AllowUnauthenticated {
+			// The token reference resolved to nothing
+			src.logger.Error("Webhook token is empty, refusing events")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		history, err := fluxnova.DecodeHistoryEvents(http.MaxBytesReader(w, r.Body, maxWebhookBody), src.client.DateLayouts())
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		batchCtx := src.batchContext(ctx)
+		events := p.normalize(batchCtx, src, history)
+
+		done := make(chan error, 1)
+		select {
+		case batches <- batch{ctx: batchCtx, events: events, done: done}:
+		case <-r.Context().Done():
+			return
+		case <-ctx.Done():
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if err := <-done; err != nil {
+			http.Error(w, "events not published", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := &http.Server{
+		Addr:              src.cfg.Stream.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return server.Shutdown(shutdownCtx)
+		case err := <-errCh:
+			return err
+		case b := <-batches:
+			err := p.publish(b.ctx, src, b.events)
+			if err != nil {
+				logging.FromContext(b.ctx).Error("Failed to publish history events", logging.Error(err))
+			}
+			b.done <- err
+		}
+	}
+}
+
+// runKafkaSource consumes history events from a Kafka topic until the context
+// is cancelled
+func (p *Pipeline) runKafkaSource(ctx context.Context, src *source) error {
+	consumer := kafka.NewConsumer(p.currentConfig().Kafka.Brokers, src.cfg.Stream.Topic, src.cfg.Stream.GroupID)
+	defer consumer.Close()
+
+	return consumer.Consume(ctx, func(key, value []byte) error {
+		ctx := src.batchContext(ctx)
+		history, err := fluxnova.DecodeHistoryEvents(bytes.NewReader(value), src.client.DateLayouts())
+		if err != nil {
+			// Skip malformed messages rather than blocking the partition
+			logging.FromContext(ctx).Warn("Skipping history event message", "key", string(key), logging.Error(err))
+			return nil
+		}
+		// Failing stops the consumer before the offset is committed, so the
+		// message is read again when the source restarts
+		return p.publish(ctx, src, p.normalize(ctx, src, history))
+	})
+}
+
+// normalize converts pushed history events to process events, dropping any
+// that can't be interpreted
+func (p *Pipeline) normalize(ctx context.Context, src *source, history []fluxnova.HistoryEvent) []fluxnova.ProcessEvent {
+	events := make([]fluxnova.ProcessEvent, 0, len(history))
+	for _, h := range history {
+		event, err := h.ToProcessEvent(src.client.DateLayouts())
+		if err != nil {
+			logging.FromContext(ctx).Warn("Skipping history event", logging.Error(err))
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
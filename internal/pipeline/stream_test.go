@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+)
+
+// historyEvents starts an instance, sets a variable and starts an activity
+const historyEvents = `{"entityType":"process-instance","eventType":"start","time":"2024-06-15T10:00:00.000+0000","processInstance":{"id":"pi-1","processDefinitionKey":"ticket","startTime":"2024-06-15T10:00:00.000+0000","state":"ACTIVE"}}
+{"entityType":"variable","eventType":"create","time":"2024-06-15T10:00:00.000+0000","variable":{"id":"v-1","name":"customerId","type":"String","value":"CUST-001","processInstanceId":"pi-1","createTime":"2024-06-15T10:00:00.000+0000"}}
+{"entityType":"activity-instance","eventType":"start","time":"2024-06-15T10:00:00.120+0000","activityInstance":{"id":"act-1","activityId":"Task_Analyze","activityType":"serviceTask","processDefinitionKey":"ticket","processInstanceId":"pi-1","startTime":"2024-06-15T10:00:00.120+0000"}}
+`
+
+func TestWebhookSource(t *testing.T) {
+	t.Setenv("CDC_TEST_WEBHOOK_TOKEN", "")
+
+	tests := []struct {
+		name          string
+		authorization string
+		allowAnon     bool
+		token         config.Secret
+		sinkErr       error
+		wantStatus    int
+		// wantRecords are the kind and key of the records published
+		wantRecords []string
+	}{
+		{
+			name:          "published",
+			token:         "s3cret",
+			authorization: "Bearer s3cret",
+			wantStatus:    http.StatusOK,
+			wantRecords:   []string{"process default/pi-1", "variables default/pi-1", "activity default/act-1"},
+		},
+		{
+			name:          "wrong token",
+			token:         "s3cret",
+			authorization: "Bearer guess",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:       "token resolving to nothing",
+			token:      "${env:CDC_TEST_WEBHOOK_TOKEN}",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:        "unauthenticated when allowed",
+			allowAnon:   true,
+			wantStatus:  http.StatusOK,
+			wantRecords: []string{"process default/pi-1", "variables default/pi-1", "activity default/act-1"},
+		},
+		{
+			name:          "sink failure is reported to the engine",
+			token:         "s3cret",
+			authorization: "Bearer s3cret",
+			sinkErr:       errors.New("broker unavailable"),
+			wantStatus:    http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := config.SourceConfig{
+				Type: config.SourceTypeWebhook,
+				Stream: config.StreamConfig{
+					ListenAddr:           freeAddr(t),
+					Path:                 "/history-events",
+					Token:                tt.token,
+					AllowUnauthenticated: tt.allowAnon,
+				},
+			}
+			p, s, sink := newTestPipeline(t, &config.Config{}, src)
+			sink.err = tt.sinkErr
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- p.runWebhookSource(ctx, s) }()
+			defer func() {
+				cancel()
+				if err := <-done; err != nil {
+					t.Errorf("runWebhookSource() = %v", err)
+				}
+			}()
+
+			resp := postEvents(t, "http://"+src.Stream.ListenAddr+src.Stream.Path, tt.authorization)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			var got []string
+			for _, sent := range sink.sent() {
+				got = append(got, sent.kind+" "+sent.key)
+			}
+			if !reflect.DeepEqual(got, tt.wantRecords) {
+				t.Errorf("published %v, want %v", got, tt.wantRecords)
+			}
+		})
+	}
+}
+
+// freeAddr returns a local address with a port nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// postEvents posts historyEvents to url as the engine's history event
+// handler would, retrying until the source is listening
+func postEvents(t *testing.T, url, authorization string) *http.Response {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(historyEvents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
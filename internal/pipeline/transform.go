@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+)
+
+const maskedValue = "****"
+
+// variableAction is what happens to a variable that survives filtering,
+// ordered from weakest to strongest redaction
+type variableAction int
+
+const (
+	actionKeep variableAction = iota
+	actionHash
+	actionTokenize
+	actionMask
+)
+
+// variableTransform filters and redacts process variables before they are
+// published, according to the rules in config.VariablesConfig
+type variableTransform struct {
+	rules       []config.VariableRule
+	tokenizeKey []byte
+}
+
+// newVariableTransform builds a transform from validated rules. The tokenize
+// key is resolved once, since rotating it would change every token.
+func newVariableTransform(cfg config.VariablesConfig) (*variableTransform, error) {
+	key, err := cfg.TokenizeKey.Value()
+	if err != nil {
+		return nil, fmt.Errorf("resolve tokenize_key: %w", err)
+	}
+	return &variableTransform{
+		rules:       cfg.Rules,
+		tokenizeKey: []byte(key),
+	}, nil
+}
+
+// Apply returns a copy of vars with the rules for processDefinitionKey applied.
+// Rules are matched in order; excludes and redactions from every matching
+// rule are combined, and a variable must match an include pattern if any
+// matching rule declares includes.
+func (t *variableTransform) Apply(processDefinitionKey string, vars map[string]any) map[string]any {
+	if len(vars) == 0 || len(t.rules) == 0 {
+		return vars
+	}
+
+	var matching []config.VariableRule
+	for _, rule := range t.rules {
+		if rule.ProcessDefinition == "" || matchAny([]string{rule.ProcessDefinition}, processDefinitionKey) {
+			matching = append(matching, rule)
+		}
+	}
+	if len(matching) == 0 {
+		return vars
+	}
+
+	result := make(map[string]any, len(vars))
+	for name, value := range vars {
+		keep, action := t.decide(matching, name)
+		if !keep {
+			continue
+		}
+		result[name] = t.redact(action, value)
+	}
+	return result
+}
+
+func (t *variableTransform) decide(rules []config.VariableRule, name string) (bool, variableAction) {
+	hasIncludes := false
+	included := false
+	action := actionKeep
+
+	for _, rule := range rules {
+		if len(rule.Include) > 0 {
+			hasIncludes = true
+			if matchAny(rule.Include, name) {
+				included = true
+			}
+		}
+		if matchAny(rule.Exclude, name) {
+			return false, actionKeep
+		}
+		// The strongest redaction wins when several rules apply
+		if matchAny(rule.Hash, name) {
+			action = max(action, actionHash)
+		}
+		if matchAny(rule.Tokenize, name) {
+			action = max(action, actionTokenize)
+		}
+		if matchAny(rule.Mask, name) {
+			action = max(action, actionMask)
+		}
+	}
+
+	if hasIncludes && !included {
+		return false, actionKeep
+	}
+	return true, action
+}
+
+func (t *variableTransform) redact(action variableAction, value any) any {
+	if value == nil {
+		return nil
+	}
+
+	switch action {
+	case actionMask:
+		return maskedValue
+	case actionHash:
+		sum := sha256.Sum256(canonicalBytes(value))
+		return hex.EncodeToString(sum[:])
+	case actionTokenize:
+		mac := hmac.New(sha256.New, t.tokenizeKey)
+		mac.Write(canonicalBytes(value))
+		return "tok_" + hex.EncodeToString(mac.Sum(nil))
+	default:
+		return value
+	}
+}
+
+// canonicalBytes returns the bytes hashed for a value: strings are used as-is
+// so tokens match identifiers hashed elsewhere, anything else is JSON-encoded
+func canonicalBytes(value any) []byte {
+	if s, ok := value.(string); ok {
+		return []byte(s)
+	}
+	data, _ := json.Marshal(value)
+	return data
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
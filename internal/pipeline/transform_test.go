@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+)
+
+func TestVariableTransformApply(t *testing.T) {
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	token := func(s string) string {
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write([]byte(s))
+		return "tok_" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	vars := map[string]any{
+		"email":      "a@example.com",
+		"subject":    "Refund",
+		"body":       "Please refund order 42",
+		"orderTotal": 42.5,
+		"customerId": "C-1",
+		"notes":      nil,
+	}
+
+	tests := []struct {
+		name       string
+		definition string
+		rules      []config.VariableRule
+		want       map[string]any
+	}{
+		{
+			name:       "no rules",
+			definition: "ticket",
+			want:       vars,
+		},
+		{
+			name:       "rule for another definition",
+			definition: "ticket",
+			rules:      []config.VariableRule{{ProcessDefinition: "order*", Exclude: []string{"*"}}},
+			want:       vars,
+		},
+		{
+			name:       "exclude",
+			definition: "ticket",
+			rules:      []config.VariableRule{{Exclude: []string{"body", "subject"}}},
+			want: map[string]any{
+				"email": "a@example.com", "orderTotal": 42.5, "customerId": "C-1", "notes": nil,
+			},
+		},
+		{
+			name:       "include",
+			definition: "ticket",
+			rules:      []config.VariableRule{{Include: []string{"order*", "customerId"}}},
+			want:       map[string]any{"orderTotal": 42.5, "customerId": "C-1"},
+		},
+		{
+			name:       "exclude wins over include",
+			definition: "ticket",
+			rules: []config.VariableRule{
+				{Include: []string{"order*", "customerId"}},
+				{ProcessDefinition: "tick?t", Exclude: []string{"customerId"}},
+			},
+			want: map[string]any{"orderTotal": 42.5},
+		},
+		{
+			name:       "redactions",
+			definition: "ticket",
+			rules: []config.VariableRule{{
+				Include:  []string{"email", "subject", "customerId", "notes"},
+				Hash:     []string{"email"},
+				Mask:     []string{"subject", "notes"},
+				Tokenize: []string{"customerId"},
+			}},
+			want: map[string]any{
+				"email":      sha("a@example.com"),
+				"subject":    maskedValue,
+				"customerId": token("C-1"),
+				"notes":      nil,
+			},
+		},
+		{
+			name:       "strongest redaction wins",
+			definition: "ticket",
+			rules: []config.VariableRule{
+				{Hash: []string{"email"}, Include: []string{"email"}},
+				{Mask: []string{"e*"}},
+			},
+			want: map[string]any{"email": maskedValue},
+		},
+		{
+			name:       "non-string values are hashed as JSON",
+			definition: "ticket",
+			rules:      []config.VariableRule{{Include: []string{"orderTotal"}, Hash: []string{"orderTotal"}}},
+			want:       map[string]any{"orderTotal": sha("42.5")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := newVariableTransform(config.VariablesConfig{TokenizeKey: "key", Rules: tt.rules})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := transform.Apply(tt.definition, vars)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariableTransformDoesNotModifyInput(t *testing.T) {
+	transform, err := newVariableTransform(config.VariablesConfig{
+		Rules: []config.VariableRule{{Mask: []string{"secret"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]any{"secret": "s3cret"}
+	transform.Apply("any", vars)
+	if vars["secret"] != "s3cret" {
+		t.Errorf("input modified: %v", vars)
+	}
+}
@@ -0,0 +1,216 @@
+// Package sink writes the pipeline's records somewhere other than Kafka:
+// JSON lines to stdout or rotating files, partitioned Parquet files, or
+// HTTP endpoints.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// Topics names the record streams a sink writes. They are the Kafka topic
+// names, so file output and Kafka carry the same streams.
+type Topics struct {
+	Events    string
+	Processes string
+	Variables string
+	Purges    string
+	Breaches  string
+}
+
+// NDJSON writes each record as a JSON line with its topic and key:
+//
+//	{"topic":"fluxnova-processes","key":"abc-123","value":{...}}
+//
+// A deleted process instance is written as lines with a null value, like a
+// Kafka tombstone.
+type NDJSON struct {
+	topics Topics
+
+	mu   sync.Mutex
+	out  io.Writer
+	file *os.File // nil when writing to stdout
+	path string
+	size int64
+
+	maxSize  int64
+	maxFiles int
+}
+
+// message is a record with its topic and key, as written by the NDJSON and
+// webhook sinks
+type message struct {
+	Topic string `json:"topic"`
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+// NewNDJSON creates a sink writing to path, or stdout if path is "" or "-".
+// A file is appended to, and renamed with a timestamp suffix before it grows
+// past maxSizeMB, keeping the newest maxFiles renamed files (all if 0).
+func NewNDJSON(path string, maxSizeMB, maxFiles int, topics Topics) (*NDJSON, error) {
+	s := &NDJSON{topics: topics, out: os.Stdout}
+	if path == "" || path == "-" {
+		return s, nil
+	}
+
+	s.path = path
+	s.maxSize = int64(maxSizeMB) << 20
+	s.maxFiles = maxFiles
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SendEvent writes an activity record
+func (s *NDJSON) SendEvent(ctx context.Context, key string, event any) error {
+	return s.write(ctx, s.topics.Events, key, event)
+}
+
+// SendProcess writes a process instance record
+func (s *NDJSON) SendProcess(ctx context.Context, key string, process any) error {
+	return s.write(ctx, s.topics.Processes, key, process)
+}
+
+// SendVariables writes a variable snapshot record
+func (s *NDJSON) SendVariables(ctx context.Context, key string, variables any) error {
+	return s.write(ctx, s.topics.Variables, key, variables)
+}
+
+// SendPurge writes a purged-in-source record
+func (s *NDJSON) SendPurge(ctx context.Context, key string, purge any) error {
+	return s.write(ctx, s.topics.Purges, key, purge)
+}
+
+// SendBreach writes an SLA breach record
+func (s *NDJSON) SendBreach(ctx context.Context, key string, breach any) error {
+	return s.write(ctx, s.topics.Breaches, key, breach)
+}
+
+// SendTo writes a record routed away from its usual topic
+func (s *NDJSON) SendTo(ctx context.Context, topic, key string, record any) error {
+	return s.write(ctx, topic, key, record)
+}
+
+// DeleteProcess writes tombstones for a process instance to the processes
+// and variables topics, and for each of eventKeys to the events topic
+func (s *NDJSON) DeleteProcess(ctx context.Context, key string, eventKeys []string) error {
+	if err := s.write(ctx, s.topics.Processes, key, nil); err != nil {
+		return err
+	}
+	if err := s.write(ctx, s.topics.Variables, key, nil); err != nil {
+		return err
+	}
+	for _, eventKey := range eventKeys {
+		if err := s.write(ctx, s.topics.Events, eventKey, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush syncs the output file to disk; stdout isn't synced
+func (s *NDJSON) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Sync()
+}
+
+// Close closes the output file
+func (s *NDJSON) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *NDJSON) write(ctx context.Context, topic, key string, value any) error {
+	data, err := json.Marshal(message{Topic: topic, Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotate %s: %w", s.path, err)
+		}
+	}
+	n, err := s.out.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Debug("Wrote record", "topic", topic, "key", key)
+	return nil
+}
+
+// open opens the output file for appending
+func (s *NDJSON) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.out, s.size = f, f, info.Size()
+	return nil
+}
+
+// rotate renames the output file, starts a new one and removes the oldest
+// rotated files beyond maxFiles
+func (s *NDJSON) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(s.path)
+	base := strings.TrimSuffix(s.path, ext)
+	rotated := base + "-" + time.Now().UTC().Format("20060102T150405.000") + ext
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	if s.maxFiles == 0 {
+		return nil
+	}
+	// The timestamp suffix sorts rotated files oldest first
+	old, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return err
+	}
+	sort.Strings(old)
+	for len(old) > s.maxFiles {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+	return nil
+}
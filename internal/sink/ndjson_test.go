@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readNDJSON returns the messages in the NDJSON files at paths, in order
+func readNDJSON(t *testing.T, paths ...string) []message {
+	t.Helper()
+	var msgs []message
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var msg message
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			msgs = append(msgs, msg)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msgs
+}
+
+func TestNDJSONRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.ndjson")
+	s, err := NewNDJSON(path, 0, 0, testTopics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	sends := []error{
+		s.SendProcess(ctx, "eu/pi-1", map[string]any{"state": "ACTIVE", "amount": 10}),
+		s.SendEvent(ctx, "eu/act-1", map[string]any{"activity_id": "Review"}),
+		s.SendVariables(ctx, "eu/pi-1", map[string]any{"variables": map[string]any{"approved": true}}),
+		s.SendTo(ctx, "fluxnova-escalations", "eu/pi-1", map[string]any{"reason": "late"}),
+		s.DeleteProcess(ctx, "eu/pi-2", []string{"eu/act-2"}),
+	}
+	for _, err := range sends {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []message{
+		{Topic: testTopics.Processes, Key: "eu/pi-1", Value: map[string]any{"state": "ACTIVE", "amount": 10.0}},
+		{Topic: testTopics.Events, Key: "eu/act-1", Value: map[string]any{"activity_id": "Review"}},
+		{Topic: testTopics.Variables, Key: "eu/pi-1", Value: map[string]any{"variables": map[string]any{"approved": true}}},
+		{Topic: "fluxnova-escalations", Key: "eu/pi-1", Value: map[string]any{"reason": "late"}},
+		{Topic: testTopics.Processes, Key: "eu/pi-2"},
+		{Topic: testTopics.Variables, Key: "eu/pi-2"},
+		{Topic: testTopics.Events, Key: "eu/act-2"},
+	}
+	if got := readNDJSON(t, path); !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+
+	// Reopening appends
+	s, err = NewNDJSON(path, 0, 0, testTopics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendProcess(ctx, "eu/pi-3", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if got := readNDJSON(t, path); len(got) != len(want)+1 || got[len(want)].Key != "eu/pi-3" {
+		t.Errorf("read %v after reopening, want the earlier records and eu/pi-3", got)
+	}
+}
+
+func TestNDJSONRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.ndjson")
+	s, err := NewNDJSON(path, 1, 0, testTopics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Small enough for every record to start a new file
+	s.maxSize = 10
+	ctx := context.Background()
+
+	if err := s.SendProcess(ctx, "eu/pi-1", map[string]any{"state": "ACTIVE"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendProcess(ctx, "eu/pi-1", map[string]any{"state": "COMPLETED"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "records-*.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files %v, want 1", rotated)
+	}
+	got := readNDJSON(t, rotated[0], path)
+	if len(got) != 2 || got[0].Value.(map[string]any)["state"] != "ACTIVE" || got[1].Value.(map[string]any)["state"] != "COMPLETED" {
+		t.Errorf("read %v, want the ACTIVE record rotated out and the COMPLETED one current", got)
+	}
+}
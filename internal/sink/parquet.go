@@ -0,0 +1,408 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// Parquet writes records as Parquet files partitioned Hive-style by topic,
+// date and process definition:
+//
+//	<dir>/<topic>/date=2024-06-15/process_definition=order/part-<time>-<n>.parquet
+//
+// The date is that of the record's _valid_from. Records without a process
+// definition, such as purges, go to process_definition=_unknown.
+//
+// Each file's columns are the top-level fields of its records, typed as
+// boolean, int64, double or string; nested values and fields of mixed types
+// are JSON-encoded strings, and fields that are null in every record are
+// left out. Files are written under a temporary name and renamed, so
+// readers never see a partial file. A deleted process instance is written
+// as a row with its _id and _deleted set to true.
+//
+// So that checkpoints don't make it write a small file per poll, Flush only
+// writes the buffered records once they have waited the flush interval.
+type Parquet struct {
+	dir           string
+	topics        Topics
+	flushInterval time.Duration
+	maxRows       int
+
+	mu         sync.Mutex
+	partitions map[partitionKey]*partition
+	seq        int
+	// lastFlush is when Flush was last called
+	lastFlush time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type partitionKey struct {
+	topic, date, definition string
+}
+
+// partition holds the records buffered for one file
+type partition struct {
+	rows  []map[string]any
+	first time.Time
+}
+
+// ErrPending is returned by Flush while the sink holds records back to write
+// them in fewer, larger files. They are written by a later Flush, or by
+// Close.
+var ErrPending = errors.New("records not written yet")
+
+// unknownDefinition is the partition of records without a process
+// definition key
+const unknownDefinition = "_unknown"
+
+// NewParquet creates a sink writing under dir. Each partition's records are
+// written as a file once maxRows are buffered or flushInterval has passed
+// since the first of them, and on Close. Once Flush is being called, records
+// buffered before a Flush are left for a later Flush to write, so the files
+// it writes and the checkpoints saved after it stay in step.
+func NewParquet(dir string, flushInterval time.Duration, maxRows int, topics Topics) (*Parquet, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Parquet{
+		dir:           dir,
+		topics:        topics,
+		flushInterval: flushInterval,
+		maxRows:       maxRows,
+		partitions:    make(map[partitionKey]*partition),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// SendEvent buffers an activity record
+func (s *Parquet) SendEvent(ctx context.Context, key string, event any) error {
+	return s.add(ctx, s.topics.Events, key, event)
+}
+
+// SendProcess buffers a process instance record
+func (s *Parquet) SendProcess(ctx context.Context, key string, process any) error {
+	return s.add(ctx, s.topics.Processes, key, process)
+}
+
+// SendVariables buffers a variable snapshot record
+func (s *Parquet) SendVariables(ctx context.Context, key string, variables any) error {
+	return s.add(ctx, s.topics.Variables, key, variables)
+}
+
+// SendPurge buffers a purged-in-source record
+func (s *Parquet) SendPurge(ctx context.Context, key string, purge any) error {
+	return s.add(ctx, s.topics.Purges, key, purge)
+}
+
+// SendBreach buffers an SLA breach record
+func (s *Parquet) SendBreach(ctx context.Context, key string, breach any) error {
+	return s.add(ctx, s.topics.Breaches, key, breach)
+}
+
+// SendTo buffers a record routed away from its usual topic
+func (s *Parquet) SendTo(ctx context.Context, topic, key string, record any) error {
+	return s.add(ctx, topic, key, record)
+}
+
+// DeleteProcess records the deletion of a process instance in the processes
+// and variables topics, and of each of eventKeys in the events topic
+func (s *Parquet) DeleteProcess(ctx context.Context, key string, eventKeys []string) error {
+	deleted := map[string]any{"_id": key, "_deleted": true}
+	if err := s.add(ctx, s.topics.Processes, key, deleted); err != nil {
+		return err
+	}
+	if err := s.add(ctx, s.topics.Variables, key, deleted); err != nil {
+		return err
+	}
+	for _, eventKey := range eventKeys {
+		if err := s.add(ctx, s.topics.Events, eventKey, map[string]any{"_id": eventKey, "_deleted": true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes every partition's buffered records once the oldest of them
+// has waited the flush interval, and until then returns ErrPending
+func (s *Parquet) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFlush = time.Now()
+
+	if len(s.partitions) == 0 {
+		return nil
+	}
+	for _, part := range s.partitions {
+		if time.Since(part.first) >= s.flushInterval {
+			return s.flushAll()
+		}
+	}
+	return ErrPending
+}
+
+// Close writes the buffered records and stops the sink
+func (s *Parquet) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushAll()
+}
+
+// flushAll writes all partitions. The caller holds s.mu.
+func (s *Parquet) flushAll() error {
+	var firstErr error
+	for key, part := range s.partitions {
+		if err := s.flush(key, part); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *Parquet) add(ctx context.Context, topic, key string, record any) error {
+	row, err := jsonRow(record)
+	if err != nil {
+		return err
+	}
+
+	pk := partitionKey{topic: topic, date: rowDate(row), definition: unknownDefinition}
+	if def, ok := row["process_definition_key"].(string); ok && def != "" {
+		pk.definition = def
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	part, ok := s.partitions[pk]
+	if !ok {
+		part = &partition{first: time.Now()}
+		s.partitions[pk] = part
+	}
+	part.rows = append(part.rows, row)
+	logging.FromContext(ctx).Debug("Buffered record", "topic", topic, "key", key)
+
+	if len(part.rows) >= s.maxRows {
+		return s.flush(pk, part)
+	}
+	return nil
+}
+
+// run writes partitions whose flush interval has passed since no Flush
+// call
+func (s *Parquet) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			for key, part := range s.partitions {
+				// Records a Flush has seen are left for Flush, or it
+				// would rarely find any of them due
+				if time.Since(part.first) < s.flushInterval || !part.first.After(s.lastFlush) {
+					continue
+				}
+				if err := s.flush(key, part); err != nil {
+					// The rows stay buffered and are retried on the next tick
+					slog.Error("Failed to write Parquet file", "topic", key.topic, logging.Error(err))
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// flush writes a partition's rows as a new file. The caller holds s.mu.
+func (s *Parquet) flush(key partitionKey, part *partition) error {
+	dir := filepath.Join(s.dir, url.PathEscape(key.topic),
+		"date="+key.date, "process_definition="+url.PathEscape(key.definition))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	s.seq++
+	name := fmt.Sprintf("part-%s-%d.parquet", time.Now().UTC().Format("20060102T150405.000"), s.seq)
+	path := filepath.Join(dir, name)
+	if err := writeParquetFile(path, part.rows); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+
+	slog.Debug("Wrote Parquet file", "path", path, "rows", len(part.rows))
+	delete(s.partitions, key)
+	return nil
+}
+
+// jsonRow returns record as JSON values, with numbers kept as json.Number
+// so integers stay exact
+func jsonRow(record any) (map[string]any, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var row map[string]any
+	if err := dec.Decode(&row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// rowDate returns the UTC date of a row's _valid_from, or today's
+func rowDate(row map[string]any) string {
+	if s, ok := row["_valid_from"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t.UTC().Format(time.DateOnly)
+		}
+	}
+	return time.Now().UTC().Format(time.DateOnly)
+}
+
+// Column kinds, from most to least specific. A column whose values have
+// different kinds is written as the least specific of them, except that
+// int64 and double columns only widen to double.
+type columnKind int
+
+const (
+	kindNull columnKind = iota
+	kindBool
+	kindInt
+	kindDouble
+	kindString
+)
+
+func valueKind(v any) columnKind {
+	switch v := v.(type) {
+	case nil:
+		return kindNull
+	case bool:
+		return kindBool
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return kindInt
+		}
+		return kindDouble
+	}
+	return kindString
+}
+
+func mergeKinds(a, b columnKind) columnKind {
+	switch {
+	case a == kindNull || a == b:
+		return b
+	case b == kindNull:
+		return a
+	case (a == kindInt && b == kindDouble) || (a == kindDouble && b == kindInt):
+		return kindDouble
+	}
+	return kindString
+}
+
+// writeParquetFile writes rows to path with a schema inferred from them
+func writeParquetFile(path string, rows []map[string]any) error {
+	kinds := make(map[string]columnKind)
+	for _, row := range rows {
+		for name, value := range row {
+			kinds[name] = mergeKinds(kinds[name], valueKind(value))
+		}
+	}
+
+	group := make(parquet.Group, len(kinds))
+	for name, kind := range kinds {
+		var node parquet.Node
+		switch kind {
+		case kindNull:
+			// Without a value there is no type to give the column; readers
+			// merging files by name see it as null
+			continue
+		case kindBool:
+			node = parquet.Leaf(parquet.BooleanType)
+		case kindInt:
+			node = parquet.Int(64)
+		case kindDouble:
+			node = parquet.Leaf(parquet.DoubleType)
+		default:
+			node = parquet.String()
+		}
+		group[name] = parquet.Optional(node)
+	}
+	schema := parquet.NewSchema("record", group)
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := parquet.NewWriter(f, schema, parquet.Compression(&parquet.Zstd))
+	columns := schema.Columns()
+	buf := make([]parquet.Row, 0, len(rows))
+	for _, row := range rows {
+		values := make(parquet.Row, len(columns))
+		for i, column := range columns {
+			value := row[column[0]]
+			if value == nil {
+				values[i] = parquet.NullValue().Level(0, 0, i)
+				continue
+			}
+			values[i] = columnValue(kinds[column[0]], value).Level(0, 1, i)
+		}
+		buf = append(buf, values)
+	}
+	if _, err := w.WriteRows(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// columnValue converts a JSON value to a column of the given kind
+func columnValue(kind columnKind, v any) parquet.Value {
+	switch kind {
+	case kindBool:
+		b, _ := v.(bool)
+		return parquet.BooleanValue(b)
+	case kindInt:
+		n, _ := v.(json.Number).Int64()
+		return parquet.Int64Value(n)
+	case kindDouble:
+		f, _ := v.(json.Number).Float64()
+		return parquet.DoubleValue(f)
+	}
+	if s, ok := v.(string); ok {
+		return parquet.ByteArrayValue([]byte(s))
+	}
+	data, _ := json.Marshal(v)
+	return parquet.ByteArrayValue(data)
+}
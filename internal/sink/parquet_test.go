@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// readParquet returns the rows of the files written for topic, by
+// partition directory relative to dir, with the columns each file has
+func readParquet(t *testing.T, dir, topic string) map[string][]map[string]any {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, topic, "*", "*", "*.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]map[string]any)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := parquet.NewReader(f)
+		for {
+			row := make(map[string]any)
+			if err := r.Read(&row); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			rel, _ := filepath.Rel(dir, filepath.Dir(path))
+			files[rel] = append(files[rel], row)
+		}
+		r.Close()
+		f.Close()
+	}
+	return files
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewParquet(dir, time.Hour, 1000, testTopics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	sends := []error{
+		s.SendProcess(ctx, "eu/pi-1", map[string]any{
+			"_id": "eu/pi-1", "_valid_from": "2024-06-15T09:00:00Z", "process_definition_key": "order",
+			"state": "ACTIVE", "amount": 10, "score": 1,
+		}),
+		s.SendProcess(ctx, "eu/pi-2", map[string]any{
+			"_id": "eu/pi-2", "_valid_from": "2024-06-15T10:00:00Z", "process_definition_key": "order",
+			"state": "COMPLETED", "amount": nil, "score": 0.5,
+		}),
+		s.SendProcess(ctx, "eu/pi-3", map[string]any{
+			"_id": "eu/pi-3", "_valid_from": "2024-06-16T09:00:00Z", "process_definition_key": "invoice",
+			"variables": map[string]any{"approved": true},
+		}),
+		s.DeleteProcess(ctx, "eu/pi-4", nil),
+	}
+	for _, err := range sends {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing has waited the flush interval yet
+	if err := s.Flush(ctx); !errors.Is(err, ErrPending) {
+		t.Fatalf("Flush() = %v, want ErrPending", err)
+	}
+	if files := readParquet(t, dir, testTopics.Processes); len(files) != 0 {
+		t.Fatalf("files written before the flush interval: %v", files)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readParquet(t, dir, testTopics.Processes)
+	for _, rows := range got {
+		sort.Slice(rows, func(i, j int) bool { return rows[i]["_id"].(string) < rows[j]["_id"].(string) })
+	}
+	// amount is an int64 column, score widens to double, variables is JSON
+	// and null values are nil
+	want := map[string][]map[string]any{
+		"fluxnova-processes/date=2024-06-15/process_definition=order": {
+			{"_id": "eu/pi-1", "_valid_from": "2024-06-15T09:00:00Z", "process_definition_key": "order", "state": "ACTIVE", "amount": int64(10), "score": 1.0},
+			{"_id": "eu/pi-2", "_valid_from": "2024-06-15T10:00:00Z", "process_definition_key": "order", "state": "COMPLETED", "amount": nil, "score": 0.5},
+		},
+		"fluxnova-processes/date=2024-06-16/process_definition=invoice": {
+			{"_id": "eu/pi-3", "_valid_from": "2024-06-16T09:00:00Z", "process_definition_key": "invoice", "variables": `{"approved":true}`},
+		},
+		"fluxnova-processes/date=" + time.Now().UTC().Format(time.DateOnly) + "/process_definition=_unknown": {
+			{"_id": "eu/pi-4", "_deleted": true},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+	if deleted := readParquet(t, dir, testTopics.Variables); len(deleted) != 1 {
+		t.Errorf("variables files %v, want one with the deletion", deleted)
+	}
+}
+
+func TestParquetFlush(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewParquet(dir, 50*time.Millisecond, 1000, testTopics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush() with nothing buffered = %v, want nil", err)
+	}
+	record := map[string]any{"_id": "eu/pi-1", "process_definition_key": "order"}
+	if err := s.SendProcess(ctx, "eu/pi-1", record); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(ctx); !errors.Is(err, ErrPending) {
+		t.Fatalf("Flush() = %v, want ErrPending", err)
+	}
+
+	// Once due, the records a Flush has seen are left for Flush to write
+	time.Sleep(1500 * time.Millisecond)
+	if files := readParquet(t, dir, testTopics.Processes); len(files) != 0 {
+		t.Fatalf("records seen by Flush written in the background: %v", files)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v, want nil once due", err)
+	}
+	if files := readParquet(t, dir, testTopics.Processes); len(files) != 1 {
+		t.Fatalf("files %v, want 1 after the flush", files)
+	}
+
+	// Records no Flush has seen are written in the background
+	if err := s.SendProcess(ctx, "eu/pi-2", record); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rows := 0
+		for _, r := range readParquet(t, dir, testTopics.Processes) {
+			rows += len(r)
+		}
+		if rows == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("record not written after its flush interval")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,425 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// WebhookEndpoint configures one endpoint of a Webhook sink. Zero batching,
+// retry and timeout settings use the defaults.
+type WebhookEndpoint struct {
+	Name string
+	URL  string
+	// Secret returns the HMAC key requests are signed with; nil sends them
+	// unsigned
+	Secret func() (string, error)
+	// Topics are globs of the topics whose records are sent; all if empty
+	Topics []string
+	// When is a boolean expression over the record's fields; all records
+	// are sent if empty
+	When string
+
+	BatchSize     int
+	BatchInterval time.Duration
+	MaxRetries    int
+	Timeout       time.Duration
+}
+
+// Webhook defaults
+const (
+	defaultWebhookBatchInterval = 5 * time.Second
+	defaultWebhookMaxRetries    = 5
+	defaultWebhookTimeout       = 10 * time.Second
+
+	// webhookQueueSize is how many records can wait for each endpoint
+	// before Send blocks
+	webhookQueueSize = 1000
+	// webhookCloseTimeout bounds how long Close waits for queued records
+	webhookCloseTimeout = 30 * time.Second
+)
+
+// webhookRetryBackoff is the wait before the first retry of a request,
+// doubling for each further retry up to a minute
+var webhookRetryBackoff = time.Second
+
+// Signature headers. The signature is the hex HMAC-SHA256 of the timestamp,
+// a dot and the request body, so a receiver can reject replayed requests.
+const (
+	SignatureHeader = "X-Fluxnova-Signature"
+	TimestampHeader = "X-Fluxnova-Timestamp"
+)
+
+// Webhook POSTs records to HTTP endpoints, each receiving the records that
+// pass its filters. A request body is one record, or a JSON array of them
+// for an endpoint with a batch size above 1, each in the NDJSON sink's
+// {"topic", "key", "value"} form.
+//
+// Each endpoint delivers from its own queue in the background, retrying
+// failed requests with exponential backoff, so a slow endpoint doesn't hold
+// up the others. Records that still fail are logged and dropped, and
+// reported by the next Flush. When an endpoint's queue is full, sending
+// blocks until there is room.
+type Webhook struct {
+	topics    Topics
+	endpoints []*endpoint
+}
+
+type endpoint struct {
+	WebhookEndpoint
+	when   *vm.Program
+	client *http.Client
+
+	queue chan message
+	// flushes asks run to deliver the queued records, sending on the
+	// channel it is sent how many records were dropped since the last flush
+	// once they are
+	flushes chan chan int
+	// abort cuts retries short when Close times out
+	abort chan struct{}
+	done  chan struct{}
+}
+
+// NewWebhook creates a sink POSTing to endpoints
+func NewWebhook(endpoints []WebhookEndpoint, topics Topics) (*Webhook, error) {
+	s := &Webhook{topics: topics}
+	for i, cfg := range endpoints {
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("endpoints[%d]", i)
+		}
+		if cfg.BatchSize == 0 {
+			cfg.BatchSize = 1
+		}
+		if cfg.BatchInterval == 0 {
+			cfg.BatchInterval = defaultWebhookBatchInterval
+		}
+		if cfg.MaxRetries == 0 {
+			cfg.MaxRetries = defaultWebhookMaxRetries
+		}
+		if cfg.Timeout == 0 {
+			cfg.Timeout = defaultWebhookTimeout
+		}
+
+		e := &endpoint{
+			WebhookEndpoint: cfg,
+			client:          &http.Client{Timeout: cfg.Timeout},
+			queue:           make(chan message, webhookQueueSize),
+			flushes:         make(chan chan int),
+			abort:           make(chan struct{}),
+			done:            make(chan struct{}),
+		}
+		if cfg.When != "" {
+			program, err := expr.Compile(cfg.When, expr.AsBool())
+			if err != nil {
+				return nil, fmt.Errorf("webhook %s: when: %w", cfg.Name, err)
+			}
+			e.when = program
+		}
+		s.endpoints = append(s.endpoints, e)
+	}
+
+	for _, e := range s.endpoints {
+		go e.run()
+	}
+	return s, nil
+}
+
+// SendEvent sends an activity record
+func (s *Webhook) SendEvent(ctx context.Context, key string, event any) error {
+	return s.send(ctx, s.topics.Events, key, event)
+}
+
+// SendProcess sends a process instance record
+func (s *Webhook) SendProcess(ctx context.Context, key string, process any) error {
+	return s.send(ctx, s.topics.Processes, key, process)
+}
+
+// SendVariables sends a variable snapshot record
+func (s *Webhook) SendVariables(ctx context.Context, key string, variables any) error {
+	return s.send(ctx, s.topics.Variables, key, variables)
+}
+
+// SendPurge sends a purged-in-source record
+func (s *Webhook) SendPurge(ctx context.Context, key string, purge any) error {
+	return s.send(ctx, s.topics.Purges, key, purge)
+}
+
+// SendBreach sends an SLA breach record
+func (s *Webhook) SendBreach(ctx context.Context, key string, breach any) error {
+	return s.send(ctx, s.topics.Breaches, key, breach)
+}
+
+// SendTo sends a record routed away from its usual topic
+func (s *Webhook) SendTo(ctx context.Context, topic, key string, record any) error {
+	return s.send(ctx, topic, key, record)
+}
+
+// DeleteProcess sends tombstones (null values) for a process instance to
+// the endpoints taking the processes and variables topics, and for each of
+// eventKeys to those taking the events topic. Tombstones skip the When
+// filters, having no fields to evaluate.
+func (s *Webhook) DeleteProcess(ctx context.Context, key string, eventKeys []string) error {
+	if err := s.send(ctx, s.topics.Processes, key, nil); err != nil {
+		return err
+	}
+	if err := s.send(ctx, s.topics.Variables, key, nil); err != nil {
+		return err
+	}
+	for _, eventKey := range eventKeys {
+		if err := s.send(ctx, s.topics.Events, eventKey, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush returns once every endpoint has delivered the records queued so
+// far, without waiting for their batch interval. It returns an error if an
+// endpoint dropped records since the last Flush, having failed to deliver
+// them after its retries.
+func (s *Webhook) Flush(ctx context.Context) error {
+	var errs []error
+	for _, e := range s.endpoints {
+		done := make(chan int, 1)
+		select {
+		case e.flushes <- done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case dropped := <-done:
+			if dropped > 0 {
+				errs = append(errs, fmt.Errorf("webhook %s: %d records not delivered", e.Name, dropped))
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close delivers the queued records and stops the endpoints, giving up on
+// records still undelivered after webhookCloseTimeout
+func (s *Webhook) Close() error {
+	for _, e := range s.endpoints {
+		close(e.queue)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookCloseTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, e := range s.endpoints {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			close(e.abort)
+			<-e.done
+			errs = append(errs, fmt.Errorf("webhook %s: gave up delivering queued records", e.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Webhook) send(ctx context.Context, topic, key string, record any) error {
+	// Encode now, so the record can't change while it waits in a queue
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	msg := message{Topic: topic, Key: key, Value: json.RawMessage(value)}
+
+	var env map[string]any
+	for _, e := range s.endpoints {
+		if !e.matchTopic(topic) {
+			continue
+		}
+		if e.when != nil && record != nil {
+			if env == nil {
+				if err := json.Unmarshal(value, &env); err != nil {
+					return err
+				}
+			}
+			matched, err := expr.Run(e.when, env)
+			if err != nil {
+				logging.FromContext(ctx).Warn("Webhook filter failed", "webhook", e.Name, "topic", topic, "key", key, logging.Error(err))
+				continue
+			}
+			b, ok := matched.(bool)
+			if !ok {
+				logging.FromContext(ctx).Warn("Webhook filter failed", "webhook", e.Name, "topic", topic, "key", key,
+					logging.Error(fmt.Errorf("got %v, want a bool", matched)))
+				continue
+			}
+			if !b {
+				continue
+			}
+		}
+
+		select {
+		case e.queue <- msg:
+			logging.FromContext(ctx).Debug("Queued record for webhook", "webhook", e.Name, "topic", topic, "key", key)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (e *endpoint) matchTopic(topic string) bool {
+	if len(e.Topics) == 0 {
+		return true
+	}
+	for _, pattern := range e.Topics {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// run batches queued records and delivers them until the queue is closed
+func (e *endpoint) run() {
+	defer close(e.done)
+	timer := time.NewTimer(e.BatchInterval)
+	timer.Stop()
+
+	var batch []message
+	// dropped counts the records given up on since the last Flush
+	dropped := 0
+	flush := func() {
+		timer.Stop()
+		if len(batch) > 0 {
+			if !e.deliver(batch) {
+				dropped += len(batch)
+			}
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case msg, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) >= e.BatchSize {
+				flush()
+			} else if len(batch) == 1 {
+				timer.Reset(e.BatchInterval)
+			}
+		case <-timer.C:
+			flush()
+		case done := <-e.flushes:
+			// Take what was queued before the flush was asked for
+			for n := len(e.queue); n > 0; n-- {
+				batch = append(batch, <-e.queue)
+				if len(batch) >= e.BatchSize {
+					flush()
+				}
+			}
+			flush()
+			done <- dropped
+			dropped = 0
+		}
+	}
+}
+
+// deliver POSTs a batch, retrying with exponential backoff, and reports
+// whether it was delivered
+func (e *endpoint) deliver(batch []message) bool {
+	var body []byte
+	var err error
+	if e.BatchSize == 1 {
+		body, err = json.Marshal(batch[0])
+	} else {
+		body, err = json.Marshal(batch)
+	}
+	if err != nil {
+		slog.Error("Failed to encode webhook request", "webhook", e.Name, logging.Error(err))
+		return false
+	}
+
+	backoff := webhookRetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := e.post(body)
+		if err == nil {
+			slog.Debug("Delivered records to webhook", "webhook", e.Name, "records", len(batch))
+			return true
+		}
+		if !retry || attempt == e.MaxRetries {
+			slog.Error("Failed to deliver records to webhook", "webhook", e.Name, "records", len(batch),
+				"attempts", attempt+1, logging.Error(err))
+			return false
+		}
+		slog.Warn("Webhook request failed, retrying", "webhook", e.Name, "retry_in", backoff.String(), logging.Error(err))
+
+		select {
+		case <-time.After(backoff):
+		case <-e.abort:
+			slog.Error("Dropping undelivered webhook records on shutdown", "webhook", e.Name, "records", len(batch))
+			return false
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// post sends one request and reports whether a failure is worth retrying
+func (e *endpoint) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", e.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if e.Secret != nil {
+		secret, err := e.Secret()
+		if err != nil {
+			return true, fmt.Errorf("resolve secret: %w", err)
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(secret, timestamp, body))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("endpoint returned %d: %s", resp.StatusCode, string(respBody))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return false, nil
+}
+
+// Sign returns the hex HMAC-SHA256 signature of a webhook request body sent
+// at timestamp (Unix seconds), as receivers should compute it to verify the
+// SignatureHeader
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,313 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testTopics = Topics{
+	Events:    "fluxnova-events",
+	Processes: "fluxnova-processes",
+	Variables: "fluxnova-variables",
+	Purges:    "fluxnova-purges",
+	Breaches:  "fluxnova-sla-breaches",
+}
+
+// receivedRequest is a request a test endpoint received
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a test endpoint answering with the given statuses in turn,
+// then 200
+type receiver struct {
+	*httptest.Server
+	requests chan receivedRequest
+
+	mu       sync.Mutex
+	statuses []int
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+	r := &receiver{requests: make(chan receivedRequest, 100), statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.requests <- receivedRequest{header: req.Header.Clone(), body: body}
+
+		r.mu.Lock()
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// received returns the requests received so far
+func (r *receiver) received() []receivedRequest {
+	var reqs []receivedRequest
+	for {
+		select {
+		case req := <-r.requests:
+			reqs = append(reqs, req)
+		default:
+			return reqs
+		}
+	}
+}
+
+func newTestWebhook(t *testing.T, endpoints ...WebhookEndpoint) *Webhook {
+	t.Helper()
+	s, err := NewWebhook(endpoints, testTopics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func flush(t *testing.T, s *Webhook) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	signed, unsigned := newReceiver(t), newReceiver(t)
+	s := newTestWebhook(t,
+		WebhookEndpoint{URL: signed.URL, Secret: func() (string, error) { return "s3cret", nil }},
+		WebhookEndpoint{URL: unsigned.URL},
+	)
+
+	if err := s.SendProcess(context.Background(), "eu/pi-1", map[string]any{"state": "ACTIVE"}); err != nil {
+		t.Fatal(err)
+	}
+	flush(t, s)
+
+	reqs := signed.received()
+	if len(reqs) != 1 {
+		t.Fatalf("signed endpoint received %d requests, want 1", len(reqs))
+	}
+	req := reqs[0]
+	timestamp := req.header.Get(TimestampHeader)
+	if want := "sha256=" + Sign("s3cret", timestamp, req.body); req.header.Get(SignatureHeader) != want {
+		t.Errorf("signature = %q, want %q", req.header.Get(SignatureHeader), want)
+	}
+	if got := req.header.Get(SignatureHeader); got == "sha256="+Sign("other", timestamp, req.body) {
+		t.Error("signature verifies with the wrong secret")
+	}
+
+	var msg struct {
+		Topic string         `json:"topic"`
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+	if err := json.Unmarshal(req.body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Topic != testTopics.Processes || msg.Key != "eu/pi-1" || msg.Value["state"] != "ACTIVE" {
+		t.Errorf("body = %s", req.body)
+	}
+
+	reqs = unsigned.received()
+	if len(reqs) != 1 {
+		t.Fatalf("unsigned endpoint received %d requests, want 1", len(reqs))
+	}
+	if reqs[0].header.Get(SignatureHeader) != "" || reqs[0].header.Get(TimestampHeader) != "" {
+		t.Errorf("unsigned request has signature headers %v", reqs[0].header)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	defer func(backoff time.Duration) { webhookRetryBackoff = backoff }(webhookRetryBackoff)
+	webhookRetryBackoff = time.Millisecond
+
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int
+		// dropped is whether Flush reports the record as not delivered
+		dropped bool
+	}{
+		{name: "success", want: 1},
+		{name: "too many requests", statuses: []int{429}, want: 2},
+		{name: "server errors", statuses: []int{500, 503}, want: 3},
+		{name: "client error", statuses: []int{400}, want: 1, dropped: true},
+		{name: "unauthorized", statuses: []int{401}, want: 1, dropped: true},
+		{name: "gives up after max retries", statuses: []int{502, 502, 502, 502}, want: 3, dropped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t, tt.statuses...)
+			s := newTestWebhook(t, WebhookEndpoint{URL: r.URL, MaxRetries: 2})
+
+			ctx := context.Background()
+			if err := s.SendEvent(ctx, "eu/act-1", map[string]any{"activity_id": "Task"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Flush(ctx); (err != nil) != tt.dropped {
+				t.Errorf("Flush() = %v, want an error %v", err, tt.dropped)
+			}
+			// Only the flush after a drop reports it
+			flush(t, s)
+
+			if got := len(r.received()); got != tt.want {
+				t.Errorf("endpoint received %d requests, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookBatching(t *testing.T) {
+	t.Run("by size", func(t *testing.T) {
+		r := newReceiver(t)
+		s := newTestWebhook(t, WebhookEndpoint{URL: r.URL, BatchSize: 2, BatchInterval: time.Hour})
+
+		for _, key := range []string{"a", "b", "c"} {
+			if err := s.SendEvent(context.Background(), key, map[string]any{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The first two fill a batch, sent without waiting for the interval
+		select {
+		case req := <-r.requests:
+			var batch []message
+			if err := json.Unmarshal(req.body, &batch); err != nil {
+				t.Fatal(err)
+			}
+			if len(batch) != 2 || batch[0].Key != "a" || batch[1].Key != "b" {
+				t.Errorf("first batch = %s, want a and b", req.body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("full batch not delivered")
+		}
+
+		flush(t, s)
+		reqs := r.received()
+		if len(reqs) != 1 {
+			t.Fatalf("flush delivered %d requests, want 1", len(reqs))
+		}
+		var batch []message
+		if err := json.Unmarshal(reqs[0].body, &batch); err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) != 1 || batch[0].Key != "c" {
+			t.Errorf("flushed batch = %s, want c", reqs[0].body)
+		}
+	})
+
+	t.Run("by interval", func(t *testing.T) {
+		r := newReceiver(t)
+		s := newTestWebhook(t, WebhookEndpoint{URL: r.URL, BatchSize: 10, BatchInterval: 50 * time.Millisecond})
+
+		for _, key := range []string{"a", "b"} {
+			if err := s.SendEvent(context.Background(), key, map[string]any{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		select {
+		case req := <-r.requests:
+			var batch []message
+			if err := json.Unmarshal(req.body, &batch); err != nil {
+				t.Fatal(err)
+			}
+			if len(batch) != 2 {
+				t.Errorf("batch = %s, want a and b", req.body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("batch not delivered after its interval")
+		}
+	})
+}
+
+func TestWebhookFilters(t *testing.T) {
+	r := newReceiver(t)
+	s := newTestWebhook(t, WebhookEndpoint{
+		URL:    r.URL,
+		Topics: []string{"fluxnova-processes", "*-escalations"},
+		When:   `state == "ACTIVE" && risk_score > 0.8`,
+	})
+	ctx := context.Background()
+
+	process := func(key string, record map[string]any) error { return s.SendProcess(ctx, key, record) }
+	sends := []error{
+		process("match", map[string]any{"state": "ACTIVE", "risk_score": 0.9}),
+		process("low-risk", map[string]any{"state": "ACTIVE", "risk_score": 0.1}),
+		process("completed", map[string]any{"state": "COMPLETED", "risk_score": 0.9}),
+		// risk_score > 0.8 fails on a null, which skips the record
+		process("no-score", map[string]any{"state": "ACTIVE"}),
+		s.SendEvent(ctx, "other-topic", map[string]any{"state": "ACTIVE", "risk_score": 0.9}),
+		s.SendTo(ctx, "fluxnova-escalations", "routed", map[string]any{"state": "ACTIVE", "risk_score": 0.95}),
+		// Tombstones skip the when filter
+		s.DeleteProcess(ctx, "deleted", []string{"deleted/act-1"}),
+	}
+	for _, err := range sends {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush(t, s)
+
+	var keys []string
+	for _, req := range r.received() {
+		var msg message
+		if err := json.Unmarshal(req.body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, msg.Key)
+	}
+	want := []string{"match", "routed", "deleted"}
+	if len(keys) != len(want) {
+		t.Fatalf("delivered %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("delivered %v, want %v", keys, want)
+			break
+		}
+	}
+}
+
+func TestWebhookNonBooleanFilter(t *testing.T) {
+	r := newReceiver(t)
+	s := newTestWebhook(t, WebhookEndpoint{URL: r.URL, When: "flagged"})
+	ctx := context.Background()
+
+	// flagged is null here, so the filter doesn't evaluate to a bool
+	if err := s.SendProcess(ctx, "unflagged", map[string]any{"state": "ACTIVE"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendProcess(ctx, "flagged", map[string]any{"flagged": true}); err != nil {
+		t.Fatal(err)
+	}
+	flush(t, s)
+
+	reqs := r.received()
+	if len(reqs) != 1 {
+		t.Fatalf("delivered %d requests, want 1", len(reqs))
+	}
+	var msg message
+	if err := json.Unmarshal(reqs[0].body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Key != "flagged" {
+		t.Errorf("delivered %s, want flagged", msg.Key)
+	}
+}
@@ -0,0 +1,260 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+)
+
+// Span is a finished span to export
+type Span struct {
+	TraceID      [16]byte
+	SpanID       [8]byte
+	ParentSpanID [8]byte // zero for a root span
+	Name         string
+	Start, End   time.Time
+	Attributes   []Attribute
+	Links        []Link
+	// Error marks the span as failed, with ErrorMessage as the reason
+	Error        bool
+	ErrorMessage string
+}
+
+// Attribute is a span attribute. Value is a string, bool or int64.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Link points at a span in another trace
+type Link struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// Exporter sends spans to an OTLP/HTTP collector (POST /v1/traces, JSON
+// encoding) from a background goroutine, so a slow or unavailable collector
+// never holds up the pipeline. Batches that don't fit in the queue are
+// dropped with a warning.
+type Exporter struct {
+	url         string
+	headers     http.Header
+	serviceName string
+	client      *http.Client
+
+	queue chan []Span
+	done  chan struct{}
+}
+
+// exportQueueSize is how many batches can wait to be sent
+const exportQueueSize = 64
+
+// NewExporter starts an exporter sending to the collector at endpoint, e.g.
+// http://localhost:4318. Headers are "Name=value" pairs sent with each
+// request, e.g. for collector authentication.
+func NewExporter(endpoint string, headers []string, serviceName string) (*Exporter, error) {
+	h := make(http.Header)
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tracing header %q: must be Name=value", header)
+		}
+		h.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	e := &Exporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:     h,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan []Span, exportQueueSize),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Export queues spans to be sent
+func (e *Exporter) Export(ctx context.Context, spans []Span) {
+	if len(spans) == 0 {
+		return
+	}
+	select {
+	case e.queue <- spans:
+	default:
+		logging.FromContext(ctx).Warn("Trace export queue full, dropping spans", "spans", len(spans))
+	}
+}
+
+// Close sends the queued spans and stops the exporter, giving up when ctx
+// is done
+func (e *Exporter) Close(ctx context.Context) error {
+	close(e.queue)
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	for spans := range e.queue {
+		if err := e.send(spans); err != nil {
+			slog.Warn("Failed to export spans", "spans", len(spans), logging.Error(err))
+		}
+	}
+}
+
+func (e *Exporter) send(spans []Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("collector returned %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// OTLP JSON request, see opentelemetry-proto's trace_service.proto. Ids are
+// hex encoded and 64-bit integers are strings.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanJSON `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	spanJSON struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Links             []linkJSON `json:"links,omitempty"`
+		Status            *status    `json:"status,omitempty"`
+	}
+	linkJSON struct {
+		TraceID string `json:"traceId"`
+		SpanID  string `json:"spanId"`
+	}
+	status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+)
+
+// Span kind and status codes from trace.proto
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+func (e *Exporter) request(spans []Span) exportRequest {
+	out := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		sj := spanJSON{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        keyValues(s.Attributes),
+		}
+		if s.ParentSpanID != ([8]byte{}) {
+			sj.ParentSpanID = hex.EncodeToString(s.ParentSpanID[:])
+		}
+		for _, l := range s.Links {
+			sj.Links = append(sj.Links, linkJSON{
+				TraceID: hex.EncodeToString(l.TraceID[:]),
+				SpanID:  hex.EncodeToString(l.SpanID[:]),
+			})
+		}
+		if s.Error {
+			sj.Status = &status{Code: statusCodeError, Message: s.ErrorMessage}
+		}
+		out = append(out, sj)
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: keyValues([]Attribute{{"service.name", e.serviceName}})},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: out}},
+	}}}
+}
+
+// scopeName identifies the connector as the instrumentation scope
+const scopeName = "github.com/refset/fluxnova-decision-observability"
+
+func keyValues(attrs []Attribute) []keyValue {
+	var kvs []keyValue
+	for _, a := range attrs {
+		var v anyValue
+		switch value := a.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, keyValue{Key: a.Key, Value: v})
+	}
+	return kvs
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+)
+
+func TestExportProcessEvent(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan exportRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding export request: %v", err)
+		}
+		requests <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	exporter, err := NewExporter(srv.URL+"/", []string{"X-Token = abc"}, "fluxnova")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *fluxnova.Time { return &fluxnova.Time{Time: start.Add(d)} }
+	ptr := func(s string) *string { return &s }
+	event := fluxnova.ProcessEvent{
+		EventType:         fluxnova.EventTypeProcessInstance,
+		ProcessInstanceID: "pi-1",
+		ProcessDefinition: "loan-approval",
+		State:             "EXTERNALLY_TERMINATED",
+		StartTime:         fluxnova.Time{Time: start},
+		EndTime:           at(time.Hour),
+		Activities: []fluxnova.HistoricActivityInstance{
+			{ID: "sub-1", ParentActivityInstanceID: ptr("pi-1"), ActivityID: "Sub_Review", ActivityType: "subProcess", ProcessInstanceID: "pi-1", StartTime: fluxnova.Time{Time: start}, EndTime: at(time.Hour)},
+			{ID: "task-1", ParentActivityInstanceID: ptr("sub-1"), ActivityID: "Task_Review", ActivityType: "userTask", ProcessInstanceID: "pi-1", StartTime: fluxnova.Time{Time: start}, EndTime: at(time.Minute)},
+			{ID: "task-2", ParentActivityInstanceID: ptr("sub-1"), ActivityID: "Task_Escalate", ActivityType: "userTask", ProcessInstanceID: "pi-1", StartTime: fluxnova.Time{Time: start}},
+		},
+	}
+	exporter.Export(context.Background(), ProcessEventSpans("eu", event))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var req *http.Request
+	var body exportRequest
+	select {
+	case req = <-requests:
+		body = <-bodies
+	default:
+		t.Fatal("no spans exported")
+	}
+
+	if req.URL.Path != "/v1/traces" {
+		t.Errorf("path = %s, want /v1/traces", req.URL.Path)
+	}
+	if got := req.Header.Get("X-Token"); got != "abc" {
+		t.Errorf("X-Token = %q, want abc", got)
+	}
+	if len(body.ResourceSpans) != 1 || len(body.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("export request = %+v, want one resource and scope", body)
+	}
+	attrs := body.ResourceSpans[0].Resource.Attributes
+	if len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue == nil || *attrs[0].Value.StringValue != "fluxnova" {
+		t.Errorf("resource attributes = %+v, want service.name fluxnova", attrs)
+	}
+
+	spans := make(map[string]spanJSON)
+	for _, s := range body.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+	if len(spans) != 3 {
+		t.Fatalf("exported spans %v, want the instance and its two finished activities", spans)
+	}
+	root, sub, task := spans["loan-approval"], spans["Sub_Review"], spans["Task_Review"]
+
+	for name, s := range spans {
+		if s.TraceID != root.TraceID {
+			t.Errorf("span %s has trace id %s, want %s", name, s.TraceID, root.TraceID)
+		}
+	}
+	if root.ParentSpanID != "" {
+		t.Errorf("root span has parent %s", root.ParentSpanID)
+	}
+	if sub.ParentSpanID != root.SpanID {
+		t.Errorf("subprocess parent = %s, want the root span %s", sub.ParentSpanID, root.SpanID)
+	}
+	if task.ParentSpanID != sub.SpanID {
+		t.Errorf("task parent = %s, want the subprocess span %s", task.ParentSpanID, sub.SpanID)
+	}
+	if root.Status == nil || root.Status.Code != statusCodeError || root.Status.Message != "EXTERNALLY_TERMINATED" {
+		t.Errorf("root status = %+v, want error EXTERNALLY_TERMINATED", root.Status)
+	}
+	if task.Status != nil {
+		t.Errorf("task status = %+v, want none", task.Status)
+	}
+	if root.StartTimeUnixNano != "1714554000000000000" {
+		t.Errorf("root start = %s, want 1714554000000000000", root.StartTimeUnixNano)
+	}
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"crypto/sha256"
+
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+)
+
+// ProcessEventSpans converts the finished parts of a process event to spans.
+// Each process instance is one trace: the instance is the root span and its
+// activities are children, nested by activity scope. A called process
+// instance links to the root span of the instance that called it.
+//
+// Trace and span ids are derived from the engine and instance ids, so the
+// spans of an instance can be exported as they finish, by different pushed
+// events, and still form one trace. Unfinished instances and activities are
+// skipped. Traces are therefore only complete for sources that see an
+// instance end (webhook and kafka sources): a poll reads each instance once,
+// usually while it is still running, so a polled trace lacks its root span
+// and the activities that finished after the poll, unless the history was
+// backfilled once finished.
+func ProcessEventSpans(engineID string, event fluxnova.ProcessEvent) []Span {
+	traceID := traceIDFor(engineID, event.ProcessInstanceID)
+	processSpanID := spanIDFor(engineID, event.ProcessInstanceID)
+
+	var spans []Span
+	if event.EventType == fluxnova.EventTypeProcessInstance && event.EndTime != nil {
+		span := Span{
+			TraceID: traceID,
+			SpanID:  processSpanID,
+			Name:    event.ProcessDefinition,
+			Start:   event.StartTime.Time,
+			End:     event.EndTime.Time,
+			Attributes: []Attribute{
+				{"fluxnova.engine_id", engineID},
+				{"fluxnova.process_instance_id", event.ProcessInstanceID},
+				{"fluxnova.process_definition_key", event.ProcessDefinition},
+				{"fluxnova.state", event.State},
+			},
+		}
+		span.Attributes = appendOptional(span.Attributes, "fluxnova.business_key", event.BusinessKey)
+		span.Attributes = appendOptional(span.Attributes, "fluxnova.tenant_id", event.TenantID)
+		if event.SuperProcessID != nil {
+			span.Attributes = append(span.Attributes, Attribute{"fluxnova.parent_process_instance_id", *event.SuperProcessID})
+			span.Links = []Link{{
+				TraceID: traceIDFor(engineID, *event.SuperProcessID),
+				SpanID:  spanIDFor(engineID, *event.SuperProcessID),
+			}}
+		}
+		if event.State == "EXTERNALLY_TERMINATED" || event.State == "INTERNALLY_TERMINATED" {
+			span.Error = true
+			span.ErrorMessage = event.State
+		}
+		spans = append(spans, span)
+	}
+
+	for _, act := range event.Activities {
+		if act.EndTime == nil {
+			continue
+		}
+
+		// Activities in the process scope have the instance as parent
+		parent := processSpanID
+		if act.ParentActivityInstanceID != nil && *act.ParentActivityInstanceID != act.ProcessInstanceID {
+			parent = spanIDFor(engineID, *act.ParentActivityInstanceID)
+		}
+
+		name := act.ActivityID
+		if act.ActivityName != nil && *act.ActivityName != "" {
+			name = *act.ActivityName
+		}
+
+		span := Span{
+			TraceID:      traceID,
+			SpanID:       spanIDFor(engineID, act.ID),
+			ParentSpanID: parent,
+			Name:         name,
+			Start:        act.StartTime.Time,
+			End:          act.EndTime.Time,
+			Attributes: []Attribute{
+				{"fluxnova.engine_id", engineID},
+				{"fluxnova.process_instance_id", act.ProcessInstanceID},
+				{"fluxnova.activity_instance_id", act.ID},
+				{"fluxnova.activity_id", act.ActivityID},
+				{"fluxnova.activity_type", act.ActivityType},
+				{"fluxnova.canceled", act.Canceled},
+			},
+		}
+		span.Attributes = appendOptional(span.Attributes, "fluxnova.assignee", act.Assignee)
+		span.Attributes = appendOptional(span.Attributes, "fluxnova.task_id", act.TaskID)
+		span.Attributes = appendOptional(span.Attributes, "fluxnova.called_process_instance_id", act.CalledProcessInstanceID)
+		if act.DurationInMillis != nil {
+			span.Attributes = append(span.Attributes, Attribute{"fluxnova.duration_millis", *act.DurationInMillis})
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+func appendOptional(attrs []Attribute, key string, value *string) []Attribute {
+	if value == nil {
+		return attrs
+	}
+	return append(attrs, Attribute{key, *value})
+}
+
+// traceIDFor derives the trace id of a process instance
+func traceIDFor(engineID, processInstanceID string) [16]byte {
+	sum := sha256.Sum256([]byte("trace/" + engineID + "/" + processInstanceID))
+	var id [16]byte
+	copy(id[:], sum[:])
+	return id
+}
+
+// spanIDFor derives the span id of a process or activity instance
+func spanIDFor(engineID, instanceID string) [8]byte {
+	sum := sha256.Sum256([]byte("span/" + engineID + "/" + instanceID))
+	var id [8]byte
+	copy(id[:], sum[:])
+	return id
+}
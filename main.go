@@ -2,41 +2,125 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
 	"github.com/refset/fluxnova-decision-observability/internal/pipeline"
 )
 
+const usage = `Usage: cdc-connector [command] [flags]
+
+Commands:
+  run            Continuously poll Fluxnova and publish to the sink (default)
+  backfill       Publish historical process instances in a time range
+  check-config   Validate config.yaml and test connectivity to Fluxnova, Kafka and XTDB
+  test-transform Apply the record transforms to sample records
+  replay         Publish the history recorded by run --record
+`
+
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
 
-	cfg, err := config.Load()
+	var err error
+	switch cmd {
+	case "run":
+		err = runConnector(args)
+	case "backfill":
+		err = runBackfill(args)
+	case "check-config":
+		err = runCheckConfig(args)
+	case "test-transform":
+		err = runTestTransform(args)
+	case "replay":
+		err = runReplay(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
 	if err != nil {
-		log.Fatal("Failed to load config:", err)
+		slog.Error("Command failed", "command", cmd, logging.Error(err))
+		os.Exit(1)
+	}
+}
+
+func runConnector(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	record := fs.String("record", "", "record the engine's REST responses to this archive file (gzip, appended to); it holds variables unredacted")
+	loader := config.NewLoader(fs)
+	fs.Parse(args)
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return err
 	}
 
 	p, err := pipeline.New(cfg)
 	if err != nil {
-		log.Fatal("Failed to create pipeline:", err)
+		return fmt.Errorf("failed to create pipeline: %w", err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	if *record != "" {
+		archive, err := fluxnova.CreateArchive(*record)
+		if err != nil {
+			return fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer func() {
+			if err := archive.Close(); err != nil {
+				slog.Error("Failed to close archive", logging.Error(err))
+			}
+		}()
+		if err := p.Record(archive); err != nil {
+			return fmt.Errorf("failed to start recording: %w", err)
+		}
+		slog.Info("Recording engine responses", "archive", *record)
+	}
+
+	ctx, cancel := signalContext()
 	defer cancel()
 
+	// Reload the configuration when the file changes or on SIGHUP
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go loader.Watch(ctx, hupCh, func(cfg *config.Config) {
+		if err := p.Reload(cfg); err != nil {
+			slog.Error("Configuration reload rejected", logging.Error(err))
+		}
+	})
+
+	if err := p.Run(ctx); err != nil {
+		return fmt.Errorf("pipeline error: %w", err)
+	}
+	return nil
+}
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	// Handle shutdown signals
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigCh
-		log.Println("Received shutdown signal")
+		slog.Info("Received shutdown signal")
 		cancel()
 	}()
 
-	if err := p.Run(ctx); err != nil {
-		log.Fatal("Pipeline error:", err)
-	}
+	return ctx, cancel
 }
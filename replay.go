@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/fluxnova"
+	"github.com/refset/fluxnova-decision-observability/internal/logging"
+	"github.com/refset/fluxnova-decision-observability/internal/pipeline"
+)
+
+// runReplay publishes the history in an archive recorded by run --record
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	sources := fs.String("source", "", "comma-separated ids of the sources to replay (default every recorded source)")
+	loader := config.NewLoader(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cdc-connector replay [flags] archive")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("replay: exactly one archive file is required")
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return err
+	}
+
+	archive, err := fluxnova.ReadArchive(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	p, err := pipeline.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create pipeline: %w", err)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	var sourceIDs []string
+	if *sources != "" {
+		sourceIDs = strings.Split(*sources, ",")
+	}
+	return p.Replay(ctx, archive, sourceIDs)
+}
@@ -171,7 +171,7 @@ if [ -z "$CONNECTOR_EXISTS" ]; then
             "config": {
                 "connector.class": "com.xtdb.kafka.connect.XtdbSinkConnector",
                 "tasks.max": "1",
-                "topics": "fluxnova-events,fluxnova-processes",
+                "topics": "fluxnova-events,fluxnova-processes,fluxnova-variables,fluxnova-purges,fluxnova-sla-breaches",
                 "xtdb.url": "jdbc:postgresql://fluxnova-xtdb:5432/xtdb",
                 "xtdb.user": "xtdb",
                 "xtdb.password": "xtdb",
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/refset/fluxnova-decision-observability/internal/config"
+	"github.com/refset/fluxnova-decision-observability/internal/pipeline"
+)
+
+// runTestTransform applies the configured record transforms to sample
+// records and prints each result with the topic it would be sent to
+func runTestTransform(args []string) error {
+	fs := flag.NewFlagSet("test-transform", flag.ExitOnError)
+	kind := fs.String("kind", config.RecordProcess, "kind of the sample records: process, activity or variables")
+	loader := config.NewLoader(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cdc-connector test-transform [flags] [records.json ...]")
+		fmt.Fprintln(fs.Output(), "\nReads JSON records (objects, arrays or one per line) from the files or stdin.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	topics := map[string]string{
+		config.RecordProcess:   cfg.Kafka.ProcessesTopic,
+		config.RecordActivity:  cfg.Kafka.EventsTopic,
+		config.RecordVariables: cfg.Kafka.VariablesTopic,
+	}
+	defaultTopic, ok := topics[*kind]
+	if !ok {
+		return fmt.Errorf("test-transform: unknown --kind %q", *kind)
+	}
+
+	transform, err := pipeline.NewRecordTransform(cfg.Pipeline.Transforms)
+	if err != nil {
+		return err
+	}
+
+	var records []map[string]any
+	if fs.NArg() == 0 {
+		if records, err = readRecords(os.Stdin); err != nil {
+			return fmt.Errorf("stdin: %w", err)
+		}
+	}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		rs, err := readRecords(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		records = append(records, rs...)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	failed := 0
+	for _, record := range records {
+		topic, err := transform.Apply(*kind, record)
+		if topic == "" {
+			topic = defaultTopic
+		}
+		result := map[string]any{"topic": topic, "record": record}
+		if err != nil {
+			result["error"] = err.Error()
+			failed++
+		}
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("test-transform: %d of %d records failed a transform", failed, len(records))
+	}
+	return nil
+}
+
+// readRecords decodes JSON objects from r, which may hold single objects,
+// arrays of objects, or a mix one after another
+func readRecords(r io.Reader) ([]map[string]any, error) {
+	var records []map[string]any
+	dec := json.NewDecoder(r)
+	for {
+		var value json.RawMessage
+		err := dec.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var batch []map[string]any
+		if err := json.Unmarshal(value, &batch); err == nil {
+			records = append(records, batch...)
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal(value, &record); err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}